@@ -0,0 +1,143 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Extensions lists the file extensions of archives holding worlds.
+var Extensions = []string{".zip", ".mcworld"}
+
+// IsArchive reports whether path names a .zip or .mcworld file by its
+// extension.
+func IsArchive(path string) bool {
+	return slices.Contains(Extensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// IsWithin reports whether the absolute path lies below the absolute
+// directory dir. Output paths are checked with it, so that replacing an
+// output never deletes its input.
+func IsWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// Create writes the contents of the directory src to a new zip archive at
+// dest. Entry names are relative to src, so an archive of a world directory
+// is a valid .mcworld file.
+func Create(src, dest string) (err error) {
+	zipFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := zipFile.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	archive := zip.NewWriter(zipFile)
+	defer func() {
+		// Close writes the central directory, so its error matters.
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(writer, file)
+		return err
+	})
+}
+
+// FindWorlds returns every directory below root, including root itself,
+// that contains a db directory.
+func FindWorlds(root string) ([]string, error) {
+	worlds, err := FindWorldsFS(os.DirFS(root))
+	if err != nil {
+		return nil, err
+	}
+
+	worldDirs := make([]string, len(worlds))
+	for i, world := range worlds {
+		worldDirs[i] = filepath.Join(root, filepath.FromSlash(world))
+	}
+	return worldDirs, nil
+}
+
+// FindWorldsFS returns every directory of fsys, including ".", that contains
+// a db directory. Directories below a db directory are not searched, and
+// neither are the __MACOSX trees of archives made by the macOS Finder.
+func FindWorldsFS(fsys fs.FS) ([]string, error) {
+	var worlds []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || p == "." {
+			return nil
+		}
+
+		if d.Name() == "db" {
+			worlds = append(worlds, path.Dir(p))
+			return fs.SkipDir
+		}
+
+		// Their resource forks mirror the world tree, db directory included.
+		if strings.HasPrefix(d.Name(), "__MACOSX") {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+
+	return worlds, err
+}
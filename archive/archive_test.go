@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFindWorldsFS(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("x")}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want []string
+	}{
+		{
+			name: "world at the root",
+			fsys: fstest.MapFS{"db/CURRENT": file, "levelname.txt": file},
+			want: []string{"."},
+		},
+		{
+			name: "nested worlds",
+			fsys: fstest.MapFS{"a/db/CURRENT": file, "b/c/db/CURRENT": file, "readme.txt": file},
+			want: []string{"a", "b/c"},
+		},
+		{
+			name: "macOS resource forks",
+			fsys: fstest.MapFS{
+				"MyWorld/db/CURRENT":            file,
+				"__MACOSX/MyWorld/db/._CURRENT": file,
+				"__MACOSX/MyWorld/._levelname":  file,
+			},
+			want: []string{"MyWorld"},
+		},
+		{
+			name: "db below a db directory",
+			fsys: fstest.MapFS{"world/db/db/CURRENT": file},
+			want: []string{"world"},
+		},
+		{
+			name: "no world",
+			fsys: fstest.MapFS{"readme.txt": file},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindWorldsFS(tt.fsys)
+			if err != nil {
+				t.Fatalf("FindWorldsFS: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("worlds = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindWorlds(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"world/db", "__MACOSX/world/db"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := FindWorlds(root)
+	if err != nil {
+		t.Fatalf("FindWorlds: %v", err)
+	}
+	want := []string{filepath.Join(root, "world")}
+	if !slices.Equal(got, want) {
+		t.Errorf("worlds = %q, want %q", got, want)
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	root := filepath.FromSlash("/worlds")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/worlds/a", true},
+		{"/worlds/a/db", true},
+		{"/worlds", false},
+		{"/worlds-old/a", false},
+		{"/", false},
+		{"/worlds/../other", false},
+		{"/worlds/..a", true},
+	}
+
+	for _, tt := range tests {
+		if got := IsWithin(filepath.FromSlash(tt.path), root); got != tt.want {
+			t.Errorf("IsWithin(%s, %s) = %v, want %v", tt.path, root, got, tt.want)
+		}
+	}
+}
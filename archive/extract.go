@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrLimit is returned when an archive exceeds its Limits.
+	ErrLimit = errors.New("archive exceeds extraction limits")
+	// ErrUnsafeEntry is returned for entries that may not be extracted, such
+	// as paths outside the destination, symlinks and devices.
+	ErrUnsafeEntry = errors.New("unsafe archive entry")
+)
+
+// Defaults used for the zero fields of Limits.
+const (
+	DefaultMaxSize  = 4 << 30
+	DefaultMaxFiles = 100000
+	// DefaultMaxRatio leaves room for zero-filled LevelDB files, which come
+	// close to the 1032:1 maximum of deflate.
+	DefaultMaxRatio = 1024
+	DefaultMaxDepth = 32
+)
+
+// Limits bounds the resources an archive may use when it is extracted. Zero
+// values use the defaults.
+type Limits struct {
+	// MaxSize is the largest total uncompressed size in bytes.
+	MaxSize int64
+	// MaxFiles is the largest number of entries.
+	MaxFiles int
+	// MaxRatio is the largest ratio between the uncompressed and compressed
+	// size of a single entry.
+	MaxRatio int
+	// MaxDepth is the largest number of path elements of an entry.
+	MaxDepth int
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxSize
+	}
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = DefaultMaxFiles
+	}
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = DefaultMaxRatio
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultMaxDepth
+	}
+	return l
+}
+
+// Extract extracts the zip archive at src into dest. Entries are checked
+// against limits before and while they are written, since the sizes in the
+// archive's headers may be forged. Files are created with 0644 and
+// directories with 0755, whatever modes the archive records.
+func Extract(src, dest string, limits Limits) error {
+	limits = limits.withDefaults()
+
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if len(r.File) > limits.MaxFiles {
+		return fmt.Errorf("%w: %d entries, at most %d allowed", ErrLimit, len(r.File), limits.MaxFiles)
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	remaining := limits.MaxSize
+	for _, f := range r.File {
+		path, err := entryPath(dest, f.Name, limits.MaxDepth)
+		if err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("%w: %s has unsupported type %s", ErrUnsafeEntry, f.Name, mode.Type())
+		}
+
+		if f.UncompressedSize64 > uint64(remaining) {
+			return fmt.Errorf("%w: more than %d bytes uncompressed", ErrLimit, limits.MaxSize)
+		}
+
+		written, err := extractFile(f, path, remaining, limits)
+		if err != nil {
+			return err
+		}
+		remaining -= written
+	}
+
+	return nil
+}
+
+// entryPath returns the destination of an archive entry, rejecting names
+// that would escape dest or nest deeper than maxDepth.
+func entryPath(dest, name string, maxDepth int) (string, error) {
+	cleaned := strings.TrimSuffix(name, "/")
+	if !filepath.IsLocal(cleaned) || strings.Contains(cleaned, `\`) {
+		return "", fmt.Errorf("%w: invalid file path: %s", ErrUnsafeEntry, name)
+	}
+
+	if depth := len(strings.Split(filepath.ToSlash(filepath.Clean(cleaned)), "/")); depth > maxDepth {
+		return "", fmt.Errorf("%w: %s is nested %d levels deep, at most %d allowed", ErrLimit, name, depth, maxDepth)
+	}
+
+	path := filepath.Join(dest, cleaned)
+	if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%w: invalid file path: %s", ErrUnsafeEntry, name)
+	}
+
+	return path, nil
+}
+
+// extractFile writes a regular entry to path. It stops as soon as the entry
+// produces more than the remaining budget or inflates beyond limits.MaxRatio
+// times its compressed size, and returns the number of bytes written.
+func extractFile(f *zip.File, path string, budget int64, limits Limits) (int64, error) {
+	limit := budget
+	if ratioLimit := int64(f.CompressedSize64) * int64(limits.MaxRatio); f.Method != zip.Store && ratioLimit < limit {
+		limit = ratioLimit
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer outFile.Close()
+
+	written, err := io.Copy(outFile, io.LimitReader(rc, limit+1))
+	if err != nil {
+		return written, err
+	}
+
+	if written > limit {
+		if limit < budget {
+			return written, fmt.Errorf("%w: %s exceeds a compression ratio of %d", ErrLimit, f.Name, limits.MaxRatio)
+		}
+		return written, fmt.Errorf("%w: more than %d bytes uncompressed", ErrLimit, limits.MaxSize)
+	}
+
+	return written, outFile.Close()
+}
@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	Name string
+	Mode fs.FileMode
+	Data []byte
+}
+
+// writeZip crafts an archive from entries, keeping names and modes exactly
+// as given.
+func writeZip(t *testing.T, entries []zipEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		mode := entry.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("CreateHeader(%q): %v", entry.Name, err)
+		}
+		if _, err := w.Write(entry.Data); err != nil {
+			t.Fatalf("Write(%q): %v", entry.Name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "input.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	src := writeZip(t, []zipEntry{
+		{Name: "world/", Mode: fs.ModeDir | 0777},
+		{Name: "world/db/CURRENT", Mode: 0777 | fs.ModeSetuid, Data: []byte("MANIFEST-000001\n")},
+		{Name: "world/levelname.txt", Data: []byte("Test")},
+	})
+	dest := filepath.Join(t.TempDir(), "out")
+
+	if err := Extract(src, dest, Limits{}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dest, "world", "db", "CURRENT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "MANIFEST-000001\n" {
+		t.Errorf("CURRENT = %q", data)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "world", "db", "CURRENT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0644 {
+		t.Errorf("file mode = %v, want -rw-r--r--", info.Mode())
+	}
+
+	info, err = os.Stat(filepath.Join(dest, "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("directory mode = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+func TestExtractRejectsAttacks(t *testing.T) {
+	zeros := make([]byte, 1<<20)
+
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		limits  func(*Limits)
+		want    error
+	}{
+		{
+			name:    "parent directory",
+			entries: []zipEntry{{Name: "../evil.txt", Data: []byte("x")}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "nested parent directory",
+			entries: []zipEntry{{Name: "world/../../evil.txt", Data: []byte("x")}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "absolute path",
+			entries: []zipEntry{{Name: "/tmp/evil.txt", Data: []byte("x")}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "backslash path",
+			entries: []zipEntry{{Name: `..\evil.txt`, Data: []byte("x")}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "symlink",
+			entries: []zipEntry{{Name: "world/db", Mode: fs.ModeSymlink | 0777, Data: []byte("/etc")}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "device",
+			entries: []zipEntry{{Name: "world/disk", Mode: fs.ModeDevice | 0644}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name:    "named pipe",
+			entries: []zipEntry{{Name: "world/pipe", Mode: fs.ModeNamedPipe | 0644}},
+			want:    ErrUnsafeEntry,
+		},
+		{
+			name: "too many files",
+			entries: []zipEntry{
+				{Name: "a.txt"}, {Name: "b.txt"}, {Name: "c.txt"},
+			},
+			limits: func(l *Limits) { l.MaxFiles = 2 },
+			want:   ErrLimit,
+		},
+		{
+			name: "total size",
+			entries: []zipEntry{
+				{Name: "a.bin", Data: zeros[:600]},
+				{Name: "b.bin", Data: zeros[:600]},
+			},
+			limits: func(l *Limits) { l.MaxSize = 1000 },
+			want:   ErrLimit,
+		},
+		{
+			name:    "compression ratio",
+			entries: []zipEntry{{Name: "bomb.bin", Data: zeros}},
+			limits:  func(l *Limits) { l.MaxRatio = 10 },
+			want:    ErrLimit,
+		},
+		{
+			name:    "depth",
+			entries: []zipEntry{{Name: strings.Repeat("d/", 5) + "file.txt", Data: []byte("x")}},
+			limits:  func(l *Limits) { l.MaxDepth = 5 },
+			want:    ErrLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := writeZip(t, tt.entries)
+			root := t.TempDir()
+			dest := filepath.Join(root, "out")
+
+			limits := Limits{}
+			if tt.limits != nil {
+				tt.limits(&limits)
+			}
+
+			err := Extract(src, dest, limits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.want)
+			}
+
+			if _, err := os.Lstat(filepath.Join(root, "evil.txt")); err == nil {
+				t.Error("entry was written outside the destination")
+			}
+		})
+	}
+}
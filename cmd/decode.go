@@ -1,28 +1,77 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
+	"github.com/yechentide/necrack/archive"
 	"github.com/yechentide/necrack/netease"
 	"github.com/yechentide/necrack/styles"
 )
 
 var decodeCmd = &cobra.Command{
-	Use:   "decode [world directory]",
+	Use:   "decode [world directory or archive]...",
 	Short: "Decrypt NetEase Minecraft world files",
 	Long: `Decrypt NetEase Minecraft world files in the specified world directory.
 The world directory should contain a 'db' subdirectory with encrypted files.
 
+The input may also be a .zip or .mcworld archive. Every world in it is
+decrypted and a timestamped archive of the same type is written next to it.
+An --output ending in .zip or .mcworld writes an archive, any other --output
+a directory, whatever the input is.
+
+Several worlds are decrypted in one run with --all, which finds every world
+below a folder such as the NetEase worlds folder, or by passing several paths
+or glob patterns. Each world is decrypted on its own, failures do not stop
+the others, and a summary table is printed at the end. With --output, each
+world is written to a directory of the same name inside it. Copies and
+backups from earlier runs (names ending in _decrypted_<time> or
+_backup_<time>) are not picked up by --all.
+
+The key is derived from the world automatically. Legacy NetEase worlds
+(90 1D 30 01 header) are detected but not supported, as their cipher is
+not known.
+
+By default the decrypted world is written to a timestamped copy next to the
+source. Use --output to choose the destination, or --in-place to decrypt the
+world itself after an automatic backup.
+
+Use --verify to check every decrypted file against the LevelDB format, so a
+wrong key or a damaged file fails the run instead of silently producing
+garbage.
+
 Example:
-  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5`,
-	Args: cobra.ExactArgs(1),
+  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5
+  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --output ./decrypted-world
+  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --in-place
+  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --verify
+  necrack decode ./exported-world.mcworld
+  necrack decode ./exported-worlds.zip --output ./decrypted-worlds
+  necrack decode ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --output ./world.mcworld
+  necrack decode --all ./ne-worlds --output ./decrypted-worlds
+  necrack decode './ne-worlds/*' ./exported-world.mcworld`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if all, _ := cmd.Flags().GetString("all"); all == "" && len(args) == 0 {
+			return fmt.Errorf("requires a world directory, an archive or --all")
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
-		worldDir := args[0]
+		allRoot, _ := cmd.Flags().GetString("all")
+		keyHex, _ := cmd.Flags().GetString("key")
+		outputDir, _ := cmd.Flags().GetString("output")
+		inPlace, _ := cmd.Flags().GetBool("in-place")
+		force, _ := cmd.Flags().GetBool("force")
+		jobs, _ := cmd.Flags().GetInt("jobs")
+		verify, _ := cmd.Flags().GetBool("verify")
 		
 		// Setup styled output from centralized styles
 		
@@ -32,30 +81,114 @@ Example:
 			TimeFormat:      "15:04:05",
 			Prefix:          "[decode]",
 		})
-		
+
+		if inPlace && outputDir != "" {
+			logger.Error("Conflicting flags", "output", outputDir, "in_place", inPlace)
+			fmt.Fprintf(os.Stderr, "❌ Error: --output and --in-place cannot be used together\n")
+			os.Exit(exitUsage)
+		}
+
+		var key []byte
+		if keyHex != "" {
+			var err error
+			key, err = parseKeyFlag(keyHex)
+			if err != nil {
+				logger.Error("Invalid key format", "key_hex", keyHex, "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error: Invalid key format: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+
+		inputs, err := expandInputs(args)
+		if err != nil {
+			logger.Error("Invalid input", "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+
+		if allRoot != "" || len(inputs) > 1 {
+			os.Exit(decodeBatch(allRoot, inputs, outputDir, inPlace, netease.WorldOptions{
+				Key:    key,
+				Force:  force,
+				Verify: verify,
+				Jobs:   jobs,
+				Logger: logger,
+			}, logger))
+		}
+		worldDir := inputs[0]
+
 		logger.Info("Starting world decryption", "world_dir", worldDir)
-		
+
 		fmt.Println(styles.DecodeHeaderStyle.Render("🔓 NetEase World Decryption"))
 		fmt.Printf("Target: %s\n\n", styles.PathStyle.Render(worldDir))
 
 		if _, err := os.Stat(worldDir); os.IsNotExist(err) {
 			logger.Error("World directory does not exist", "world_dir", worldDir)
 			fmt.Fprintf(os.Stderr, "❌ Error: World directory '%s' does not exist\n", worldDir)
-			os.Exit(1)
+			os.Exit(exitFailure)
+		}
+
+		inputArchive := archive.IsArchive(worldDir)
+		if inPlace && (inputArchive || archive.IsArchive(outputDir)) {
+			logger.Error("Conflicting flags", "input", worldDir, "in_place", inPlace)
+			fmt.Fprintf(os.Stderr, "❌ Error: --in-place cannot be used with archives\n")
+			os.Exit(exitUsage)
 		}
 
 		logger.Info("World directory found, starting decryption process")
 
-		decryptedDir, err := netease.DecryptWorldDB(worldDir)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var verifyMu sync.Mutex
+		verifyCounts := make(map[netease.CheckStatus]int)
+
+		opts := netease.WorldOptions{
+			Key:     key,
+			Force:   force,
+			Verify:  verify,
+			Jobs:    jobs,
+			Context: ctx,
+			Logger:  logger,
+			Progress: func(event netease.ProgressEvent) {
+				switch event.Kind {
+				case netease.ProgressFileProcessed:
+					fmt.Printf("[%d/%d] Decrypted: %s\n", event.Done, event.Total, event.Path)
+				case netease.ProgressFileVerified:
+					verifyMu.Lock()
+					verifyCounts[event.Check.Status]++
+					verifyMu.Unlock()
+					if event.Check.Status == netease.CheckWarning || event.Check.Status == netease.CheckFailed {
+						fmt.Printf("  %s %s: %s\n", event.Check.Status, event.Path, event.Check.Detail)
+					}
+				}
+			},
+		}
+
+		var decryptedDir string
+		switch {
+		case inputArchive:
+			decryptedDir, err = decodeArchive(worldDir, outputDir, opts)
+		case archive.IsArchive(outputDir):
+			decryptedDir, err = decodeToArchive(worldDir, outputDir, opts)
+		default:
+			opts.OutputDir = outputDir
+			opts.InPlace = inPlace
+			decryptedDir, err = netease.DecryptWorldDBWithOptions(worldDir, opts)
+		}
 		if err != nil {
 			logger.Error("Decryption failed", "world_dir", worldDir, "error", err)
 			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 
 		duration := time.Since(start)
 		logger.Info("Decryption completed successfully", "world_dir", worldDir, "decrypted_dir", decryptedDir, "duration", duration)
 		fmt.Println(styles.SuccessStyle.Render("✅ Decryption completed successfully!"))
+		if verify {
+			fmt.Printf("🔍 Verified: %d passed, %d warnings, %d unverified\n",
+				verifyCounts[netease.CheckPassed], verifyCounts[netease.CheckWarning], verifyCounts[netease.CheckUnverified])
+		}
 		fmt.Printf("📁 Decrypted world saved to: %s\n", styles.PathStyle.Render(decryptedDir))
 		fmt.Printf("⏱️  Completed in %v\n", duration)
 	},
@@ -63,6 +196,13 @@ Example:
 
 func init() {
 	rootCmd.AddCommand(decodeCmd)
+	decodeCmd.Flags().String("all", "", "Decrypt every world found below this directory")
+	decodeCmd.Flags().StringP("key", "k", "", "Hex key to use instead of deriving it")
+	decodeCmd.Flags().StringP("output", "o", "", "Directory, .zip or .mcworld file to write the decrypted world to")
+	decodeCmd.Flags().Bool("in-place", false, "Decrypt the world directory itself after creating a backup")
+	decodeCmd.Flags().BoolP("force", "f", false, "Overwrite the output if it already exists")
+	decodeCmd.Flags().Bool("verify", false, "Check that every decrypted file is valid LevelDB data")
+	decodeCmd.Flags().IntP("jobs", "j", 0, "Number of files to decrypt concurrently (default: number of CPUs)")
 
 	// Here you will define your flags and configuration settings.
 
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yechentide/necrack/archive"
+	"github.com/yechentide/necrack/netease"
+)
+
+// decodeArchive decrypts every world in the .zip or .mcworld file src. The
+// result is written to output, which is an archive if it has an archive
+// extension and a directory otherwise. An empty output places a
+// timestamped archive next to src.
+func decodeArchive(src, output string, opts netease.WorldOptions) (string, error) {
+	if output == "" {
+		ext := filepath.Ext(src)
+		timestamp := time.Now().Format("20060102_150405")
+		output = strings.TrimSuffix(src, ext) + "_decrypted_" + timestamp + ext
+	}
+	if err := checkOutput(src, output, opts.Force); err != nil {
+		return "", err
+	}
+
+	// Staging next to the output keeps the final rename on one file system.
+	tempDir, err := os.MkdirTemp(filepath.Dir(output), ".necrack-decode-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	extractDir := filepath.Join(tempDir, "extracted")
+	if err := archive.Extract(src, extractDir, archive.Limits{}); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", src, err)
+	}
+
+	worldDirs, err := archive.FindWorlds(extractDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to find worlds in %s: %w", src, err)
+	}
+	if len(worldDirs) == 0 {
+		return "", fmt.Errorf("no world with a db directory found in %s", src)
+	}
+
+	for i, worldDir := range worldDirs {
+		relPath, _ := filepath.Rel(extractDir, worldDir)
+		opts.Logger.Info("Decrypting world from archive", "world", filepath.ToSlash(relPath), "index", i+1, "count", len(worldDirs))
+
+		// Decrypted worlds replace the extracted ones, so the files around
+		// them keep their place in the output.
+		opts.OutputDir = filepath.Join(tempDir, fmt.Sprintf("world-%d", i))
+		if _, err := netease.DecryptWorldDBWithOptions(worldDir, opts); err != nil {
+			if len(worldDirs) > 1 {
+				return "", fmt.Errorf("%s: %w", filepath.ToSlash(relPath), err)
+			}
+			return "", err
+		}
+		if err := os.RemoveAll(worldDir); err != nil {
+			return "", fmt.Errorf("failed to replace extracted world: %w", err)
+		}
+		if err := os.Rename(opts.OutputDir, worldDir); err != nil {
+			return "", fmt.Errorf("failed to replace extracted world: %w", err)
+		}
+	}
+
+	return output, writeOutput(extractDir, output, tempDir)
+}
+
+// decodeToArchive decrypts the world directory worldDir into the .zip or
+// .mcworld file output. The world is stored at the root of the archive.
+func decodeToArchive(worldDir, output string, opts netease.WorldOptions) (string, error) {
+	if err := checkOutput(worldDir, output, opts.Force); err != nil {
+		return "", err
+	}
+
+	tempDir, err := os.MkdirTemp(filepath.Dir(output), ".necrack-decode-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	opts.OutputDir = filepath.Join(tempDir, "world")
+	decryptedDir, err := netease.DecryptWorldDBWithOptions(worldDir, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return output, writeOutput(decryptedDir, output, tempDir)
+}
+
+// checkOutput fails if output exists and force is not set, or if output
+// overlaps the input, before any work is done.
+func checkOutput(input, output string, force bool) error {
+	inputAbs, err := filepath.Abs(input)
+	if err != nil {
+		return fmt.Errorf("failed to resolve input path: %w", err)
+	}
+	outputAbs, err := filepath.Abs(output)
+	if err != nil {
+		return fmt.Errorf("failed to resolve output path: %w", err)
+	}
+	// Replacing such an output deletes the input with it.
+	if outputAbs == inputAbs {
+		return fmt.Errorf("output %s is the input", output)
+	}
+	if archive.IsWithin(inputAbs, outputAbs) {
+		return fmt.Errorf("output %s contains the input %s", output, input)
+	}
+	if archive.IsWithin(outputAbs, inputAbs) {
+		return fmt.Errorf("output %s is inside the input %s", output, input)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		if !force {
+			return fmt.Errorf("output %s already exists", output)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check output: %w", err)
+	}
+	return os.MkdirAll(filepath.Dir(output), 0755)
+}
+
+// writeOutput moves the directory src to output, zipping it first if output
+// is an archive. The archive is built in tempDir, so an existing output is
+// only replaced once the new one is complete.
+func writeOutput(src, output, tempDir string) error {
+	if archive.IsArchive(output) {
+		partial := filepath.Join(tempDir, filepath.Base(output))
+		if err := archive.Create(src, partial); err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		if err := os.Rename(partial, output); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		return nil
+	}
+
+	if err := os.RemoveAll(output); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", output, err)
+	}
+	if err := os.Rename(src, output); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/yechentide/necrack/archive"
+	"github.com/yechentide/necrack/netease"
+	"github.com/yechentide/necrack/styles"
+)
+
+// Statuses of a world in the batch summary.
+const (
+	batchDecrypted = "decrypted"
+	batchSkipped   = "skipped"
+	batchFailed    = "failed"
+	batchCanceled  = "canceled"
+)
+
+// generatedWorld matches the copies and backups written next to worlds by
+// earlier runs, which --all does not decrypt again.
+var generatedWorld = regexp.MustCompile(`_(decrypted|encrypted|backup)_\d{8}_\d{6}$`)
+
+// batchInput is a world directory or archive to decrypt in batch mode.
+type batchInput struct {
+	Path string
+	// Rel is the path of the output below --output.
+	Rel string
+}
+
+// batchResult is the outcome of one world of a batch.
+type batchResult struct {
+	Input     batchInput
+	LevelName string
+	Files     int
+	Status    string
+	Err       error
+	Output    string
+	Duration  time.Duration
+}
+
+// expandInputs expands the glob patterns among args. Shells usually expand
+// them already, but not on Windows or when quoted. Arguments that match
+// nothing are kept, so the missing path is reported.
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			inputs = append(inputs, arg)
+		}
+		inputs = append(inputs, matches...)
+	}
+	return inputs, nil
+}
+
+// collectBatchInputs returns every world below root followed by paths, in
+// order and without duplicates. Inputs whose output paths would collide,
+// like a/world and b/world, get a numbered suffix.
+func collectBatchInputs(root string, paths []string, logger *log.Logger) ([]batchInput, error) {
+	var inputs []batchInput
+	seen := make(map[string]bool)
+	// Compared case-insensitively, as the output may be on such a file system.
+	rels := make(map[string]bool)
+	add := func(path, rel string) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if seen[abs] {
+			return
+		}
+		seen[abs] = true
+
+		unique := rel
+		ext := ""
+		if archive.IsArchive(rel) {
+			ext = filepath.Ext(rel)
+		}
+		for n := 2; rels[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(rel, ext), n, ext)
+		}
+		if unique != rel {
+			logger.Warn("Output path already taken by another world, renaming", "world", path, "output", unique)
+		}
+		rels[strings.ToLower(unique)] = true
+		inputs = append(inputs, batchInput{Path: path, Rel: unique})
+	}
+
+	if root != "" {
+		worldDirs, err := archive.FindWorlds(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find worlds in %s: %w", root, err)
+		}
+		for _, worldDir := range worldDirs {
+			if generatedWorld.MatchString(filepath.Base(worldDir)) {
+				logger.Debug("Skipping world written by an earlier run", "world_dir", worldDir)
+				continue
+			}
+			rel, err := filepath.Rel(root, worldDir)
+			if err != nil || rel == "." {
+				rel = filepath.Base(worldDir)
+			}
+			add(worldDir, rel)
+		}
+	}
+
+	for _, path := range paths {
+		add(path, filepath.Base(path))
+	}
+
+	return inputs, nil
+}
+
+// decodeBatch decrypts the worlds below root and the worlds and archives in
+// paths, prints a summary and returns the exit code. With outputDir, every
+// world is written to its path below root, or its name, inside outputDir.
+func decodeBatch(root string, paths []string, outputDir string, inPlace bool, opts netease.WorldOptions, logger *log.Logger) int {
+	start := time.Now()
+
+	fmt.Println(styles.DecodeHeaderStyle.Render("🔓 NetEase World Batch Decryption"))
+	if root != "" {
+		fmt.Printf("Root: %s\n", styles.PathStyle.Render(root))
+	}
+
+	if archive.IsArchive(outputDir) {
+		logger.Error("Invalid output for a batch", "output", outputDir)
+		fmt.Fprintf(os.Stderr, "❌ Error: --output must be a directory when decrypting several worlds\n")
+		return exitUsage
+	}
+
+	inputs, err := collectBatchInputs(root, paths, logger)
+	if err != nil {
+		logger.Error("Failed to collect worlds", "root", root, "error", err)
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		return exitFailure
+	}
+	if len(inputs) == 0 {
+		logger.Error("No worlds found", "root", root)
+		fmt.Fprintf(os.Stderr, "❌ Error: No worlds found in '%s'\n", root)
+		return exitFailure
+	}
+	fmt.Printf("Found %d worlds\n\n", len(inputs))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts.Context = ctx
+
+	results := runDecodeBatch(inputs, outputDir, inPlace, opts, logger)
+	printBatchSummary(results, time.Since(start))
+	return batchExitCode(results)
+}
+
+// runDecodeBatch decrypts every input independently and returns the results
+// in order. Failed worlds do not stop the batch; cancellation does, and the
+// remaining worlds are reported as canceled.
+func runDecodeBatch(inputs []batchInput, outputDir string, inPlace bool, opts netease.WorldOptions, logger *log.Logger) []batchResult {
+	results := make([]batchResult, len(inputs))
+	ctx := opts.Context
+
+	for i, input := range inputs {
+		result := &results[i]
+		result.Input = input
+		result.LevelName = readLevelName(input.Path)
+
+		if ctx != nil && ctx.Err() != nil {
+			result.Status = batchCanceled
+			result.Err = ctx.Err()
+			continue
+		}
+
+		fmt.Printf("[%d/%d] %s\n", i+1, len(inputs), styles.PathStyle.Render(input.Path))
+		start := time.Now()
+
+		// Files are decrypted concurrently, so progress events race.
+		var files atomic.Int64
+		worldOpts := opts
+		worldOpts.Logger = logger.With("world", input.Rel)
+		worldOpts.Progress = func(event netease.ProgressEvent) {
+			if event.Kind == netease.ProgressFileProcessed {
+				files.Add(1)
+			}
+		}
+
+		output := ""
+		if outputDir != "" {
+			output = filepath.Join(outputDir, input.Rel)
+		}
+
+		var err error
+		switch {
+		case inPlace && archive.IsArchive(input.Path):
+			err = fmt.Errorf("--in-place cannot be used with archives")
+		case archive.IsArchive(input.Path):
+			result.Output, err = decodeArchive(input.Path, output, worldOpts)
+		default:
+			worldOpts.OutputDir = output
+			worldOpts.InPlace = inPlace
+			result.Output, err = netease.DecryptWorldDBWithOptions(input.Path, worldOpts)
+		}
+		result.Duration = time.Since(start)
+		result.Files = int(files.Load())
+		result.Err = err
+
+		switch {
+		case err == nil:
+			result.Status = batchDecrypted
+		case errors.Is(err, netease.ErrVanillaWorld):
+			result.Status = batchSkipped
+		case ctx != nil && ctx.Err() != nil:
+			result.Status = batchCanceled
+		default:
+			result.Status = batchFailed
+		}
+
+		switch result.Status {
+		case batchDecrypted:
+			fmt.Printf("  📁 %s\n", styles.PathStyle.Render(result.Output))
+		case batchSkipped:
+			fmt.Printf("  %s\n", styles.MutedStyle.Render("Not encrypted, skipped"))
+		case batchFailed:
+			logger.Error("World failed, continuing", "world", input.Path, "error", err)
+		}
+	}
+
+	return results
+}
+
+// readLevelName returns the name in the levelname.txt of a world directory,
+// or of an archive holding a single world at its root, or "-" if there is
+// none.
+func readLevelName(path string) string {
+	var data []byte
+	var err error
+	if archive.IsArchive(path) {
+		data, err = readZipEntry(path, "levelname.txt")
+	} else {
+		data, err = os.ReadFile(filepath.Join(path, "levelname.txt"))
+	}
+	if err != nil {
+		return "-"
+	}
+	name := strings.TrimSpace(string(data))
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// readZipEntry returns the contents of a small entry of a zip archive.
+func readZipEntry(path, name string) ([]byte, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, 4096))
+}
+
+// printBatchSummary prints a table with a row per world and the errors of
+// the worlds that did not succeed.
+func printBatchSummary(results []batchResult, total time.Duration) {
+	header := []string{"WORLD", "LEVEL NAME", "FILES", "STATUS", "DURATION"}
+	rows := make([][]string, len(results))
+	for i, result := range results {
+		duration := "-"
+		if result.Duration > 0 {
+			duration = result.Duration.Round(time.Millisecond).String()
+		}
+		rows[i] = []string{
+			filepath.ToSlash(result.Input.Rel),
+			result.LevelName,
+			fmt.Sprint(result.Files),
+			result.Status,
+			duration,
+		}
+	}
+
+	// Widths are measured in cells, as level names are often CJK.
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], lipgloss.Width(cell))
+		}
+	}
+	formatRow := func(row []string) string {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell + strings.Repeat(" ", widths[i]-lipgloss.Width(cell))
+		}
+		return strings.TrimRight(strings.Join(cells, "  "), " ")
+	}
+
+	fmt.Println()
+	fmt.Println(styles.HeaderStyle.Render(formatRow(header)))
+	counts := make(map[string]int)
+	for i, result := range results {
+		counts[result.Status]++
+		line := formatRow(rows[i])
+		switch result.Status {
+		case batchFailed, batchCanceled:
+			line = styles.ErrorStyle.Render(line)
+		case batchSkipped:
+			line = styles.MutedStyle.Render(line)
+		}
+		fmt.Println(line)
+	}
+
+	var failures []batchResult
+	for _, result := range results {
+		if result.Status == batchFailed || result.Status == batchSkipped {
+			failures = append(failures, result)
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Println()
+		for _, result := range failures {
+			fmt.Printf("%s %s: %v\n", result.Status, styles.PathStyle.Render(result.Input.Path), result.Err)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("📊 %d decrypted, %d skipped, %d failed, %d canceled in %v\n",
+		counts[batchDecrypted], counts[batchSkipped], counts[batchFailed], counts[batchCanceled], total.Round(time.Millisecond))
+}
+
+// batchExitCode returns the exit code of a batch: success unless a world
+// failed or the batch was interrupted.
+func batchExitCode(results []batchResult) int {
+	code := 0
+	for _, result := range results {
+		switch result.Status {
+		case batchCanceled:
+			return exitCanceled
+		case batchFailed:
+			code = exitBatchFailed
+		}
+	}
+	return code
+}
@@ -47,30 +47,38 @@ Example:
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
 			logger.Error("File does not exist", "file_path", filePath)
 			fmt.Fprintf(os.Stderr, "❌ Error: File '%s' does not exist\n", filePath)
-			os.Exit(1)
+			os.Exit(exitFailure)
 		}
 
-		key, err := netease.ParseHexKey(keyHex)
+		codecName, _ := cmd.Flags().GetString("codec")
+		codec, err := lookupCodec(codecName)
+		if err != nil {
+			logger.Error("Invalid codec", "codec", codecName, "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+
+		key, err := netease.ParseCodecHexKey(codec, keyHex)
 		if err != nil {
 			logger.Error("Invalid key format", "key_hex", keyHex, "error", err)
 			fmt.Fprintf(os.Stderr, "❌ Error: Invalid key format: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitUsage)
 		}
 
 		logger.Info("Key parsed successfully, starting encryption")
 
-		encrypted, err := netease.EncryptFile(filePath, key)
+		encrypted, err := netease.EncryptFileWithCodec(filePath, codec, key)
 		if err != nil {
 			logger.Error("Encryption failed", "file_path", filePath, "error", err)
 			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 
 		outputPath := filePath + ".encrypted"
 		if err := os.WriteFile(outputPath, encrypted, 0644); err != nil {
 			logger.Error("Failed to write encrypted file", "output_path", outputPath, "error", err)
 			fmt.Fprintf(os.Stderr, "❌ Error writing encrypted file: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitFailure)
 		}
 
 		duration := time.Since(start)
@@ -88,4 +96,5 @@ Example:
 
 func init() {
 	rootCmd.AddCommand(encodeCmd)
+	encodeCmd.Flags().String("codec", netease.DefaultCodec.Name(), "Encryption format")
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/yechentide/necrack/netease"
+	"github.com/yechentide/necrack/styles"
+)
+
+var encodeWorldCmd = &cobra.Command{
+	Use:   "encode-world [world directory] [key]",
+	Short: "Encrypt a vanilla Bedrock world using NetEase format",
+	Long: `Encrypt every LevelDB file of a vanilla Bedrock world so that NetEase
+Minecraft clients can load it. This is the inverse of the decode command.
+
+The world directory should contain a 'db' subdirectory with unencrypted files.
+The key should be provided as a hex string (e.g., "1a2b3c4d5e6f7a8b").
+If the key is omitted, a random key is generated and printed.
+
+Example:
+  necrack encode-world ./worlds/my-world 1a2b3c4d5e6f7a8b
+  necrack encode-world ./worlds/my-world`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		worldDir := args[0]
+
+		// Setup logger
+		logger := log.NewWithOptions(nil, log.Options{
+			ReportTimestamp: true,
+			TimeFormat:      "15:04:05",
+			Prefix:          "[encode-world]",
+		})
+
+		logger.Info("Starting world encryption", "world_dir", worldDir)
+
+		fmt.Println(styles.EncodeHeaderStyle.Render("🔒 NetEase World Encryption"))
+		fmt.Printf("Target: %s\n", styles.PathStyle.Render(worldDir))
+
+		if _, err := os.Stat(worldDir); os.IsNotExist(err) {
+			logger.Error("World directory does not exist", "world_dir", worldDir)
+			fmt.Fprintf(os.Stderr, "❌ Error: World directory '%s' does not exist\n", worldDir)
+			os.Exit(exitFailure)
+		}
+
+		codecName, _ := cmd.Flags().GetString("codec")
+		codec, err := lookupCodec(codecName)
+		if err != nil {
+			logger.Error("Invalid codec", "codec", codecName, "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+
+		var key []byte
+		if len(args) == 2 {
+			key, err = netease.ParseCodecHexKey(codec, args[1])
+			if err != nil {
+				logger.Error("Invalid key format", "key_hex", args[1], "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error: Invalid key format: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		} else {
+			key, err = netease.GenerateCodecKey(codec)
+			if err != nil {
+				logger.Error("Key generation failed", "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			logger.Info("Generated random key")
+		}
+
+		keyHex := hex.EncodeToString(key)
+		fmt.Printf("Key:    %s\n\n", styles.KeyStyle.Render(keyHex))
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		encryptedDir, err := netease.EncryptWorldDBWithOptions(worldDir, netease.WorldOptions{
+			Key:     key,
+			Codec:   codec,
+			Context: ctx,
+			Logger:  logger,
+			Progress: func(event netease.ProgressEvent) {
+				if event.Kind == netease.ProgressFileProcessed {
+					fmt.Printf("[%d/%d] Encrypted: %s\n", event.Done, event.Total, event.Path)
+				}
+			},
+		})
+		if err != nil {
+			logger.Error("Encryption failed", "world_dir", worldDir, "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+
+		duration := time.Since(start)
+		logger.Info("Encryption completed successfully", "world_dir", worldDir, "encrypted_dir", encryptedDir, "duration", duration)
+		fmt.Println(styles.SuccessStyle.Render("✅ Encryption completed successfully!"))
+		fmt.Printf("📁 Encrypted world saved to: %s\n", styles.PathStyle.Render(encryptedDir))
+		fmt.Printf("🔑 Key: %s\n", styles.KeyStyle.Render(keyHex))
+		fmt.Printf("⏱️  Completed in %v\n", duration)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(encodeWorldCmd)
+	encodeWorldCmd.Flags().String("codec", netease.DefaultCodec.Name(), "Encryption format")
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yechentide/necrack/netease"
+)
+
+// Exit codes of necrack. Keep exitCodesHelp in sync.
+const (
+	exitFailure       = 1
+	exitUsage         = 2
+	exitKeyMismatch   = 3
+	exitLegacy        = 4
+	exitVanilla       = 5
+	exitNoManifest    = 6
+	exitUnknownHeader = 7
+	exitCorrupted     = 8
+	exitBatchFailed   = 9
+	exitCanceled      = 130
+)
+
+const exitCodesHelp = `Exit codes:
+  0    Success
+  1    Any other failure
+  2    Invalid command line: unknown flags, bad arguments or a malformed key
+  3    The key does not match the world
+  4    Legacy NetEase world, which is not supported
+  5    Vanilla Bedrock world, there is nothing to decrypt
+  6    No MANIFEST file to derive the key from
+  7    A file has an unknown header
+  8    The world is corrupted: inspect found damaged files, or decrypted files
+       other than CURRENT and the MANIFEST failed verification
+  9    decode failed for at least one world of a batch
+  130  Interrupted`
+
+// exitCode returns the exit code for an error returned by the netease
+// package. ErrNoManifest is checked late, as it is only one of the reasons
+// joined into a failed key derivation.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return exitCanceled
+	case errors.Is(err, netease.ErrKeyMismatch):
+		return exitKeyMismatch
+	case errors.Is(err, netease.ErrCorrupted):
+		return exitCorrupted
+	case errors.Is(err, netease.ErrLegacyEncryption):
+		return exitLegacy
+	case errors.Is(err, netease.ErrVanillaWorld):
+		return exitVanilla
+	case errors.Is(err, netease.ErrUnknownHeader):
+		return exitUnknownHeader
+	case errors.Is(err, netease.ErrNoManifest):
+		return exitNoManifest
+	default:
+		return exitFailure
+	}
+}
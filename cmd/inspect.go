@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/yechentide/necrack/netease"
+	"github.com/yechentide/necrack/styles"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [path]",
+	Short: "Report the encryption state of worlds and their files",
+	Long: `Inspect every world found in a world directory, a directory of worlds or a
+.zip/.mcworld archive without modifying anything.
+
+For each file in a world's 'db' directory the report shows its header type,
+its size and whether it is valid LevelDB data, decrypting it with the derived
+key where needed. Each world gets a summary verdict: encrypted, mixed,
+vanilla or corrupted.
+
+Example:
+  necrack inspect ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5
+  necrack inspect ./ne-worlds
+  necrack inspect ./backup.mcworld --format json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputPath := args[0]
+		format, _ := cmd.Flags().GetString("format")
+
+		// Setup logger
+		logger := log.NewWithOptions(nil, log.Options{
+			ReportTimestamp: true,
+			TimeFormat:      "15:04:05",
+			Prefix:          "[inspect]",
+		})
+
+		if format != "text" && format != "json" {
+			logger.Error("Invalid output format", "format", format)
+			fmt.Fprintf(os.Stderr, "❌ Error: Unknown format '%s', expected text or json\n", format)
+			os.Exit(exitUsage)
+		}
+
+		report, err := netease.Inspect(inputPath)
+		if err != nil {
+			logger.Error("Inspection failed", "path", inputPath, "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(report)
+		} else {
+			printInspectReport(report)
+		}
+
+		for _, world := range report.Worlds {
+			if world.Verdict == netease.VerdictCorrupted {
+				os.Exit(exitCorrupted)
+			}
+		}
+	},
+}
+
+func printInspectReport(report *netease.InspectReport) {
+	fmt.Println(styles.HeaderStyle.Render("🔍 NetEase World Inspection"))
+	fmt.Printf("Target: %s\n", styles.PathStyle.Render(report.Path))
+
+	for _, world := range report.Worlds {
+		fmt.Println()
+		fmt.Printf("World:   %s\n", styles.PathStyle.Render(world.Path))
+
+		verdict := string(world.Verdict)
+		switch world.Verdict {
+		case netease.VerdictCorrupted:
+			verdict = styles.ErrorStyle.Render(verdict)
+		case netease.VerdictMixed:
+			verdict = styles.InfoStyle.Render(verdict)
+		default:
+			verdict = styles.SuccessStyle.Render(verdict)
+		}
+		fmt.Printf("Verdict: %s\n", verdict)
+
+		switch {
+		case world.Key != "":
+			fmt.Printf("Key:     %s (%s)\n", styles.KeyStyle.Render(world.Key), world.KeyStrategy)
+		case world.KeyError != "":
+			fmt.Printf("Key:     %s\n", styles.MutedStyle.Render(world.KeyError))
+		}
+		fmt.Println()
+
+		for _, file := range world.Files {
+			line := fmt.Sprintf("  %-14s %-10s %10d  %s", file.Header, file.Check, file.Size, file.Name)
+			if file.Detail != "" {
+				line += styles.MutedStyle.Render(" - " + file.Detail)
+			}
+			if file.Check == netease.CheckFailed {
+				line = styles.ErrorStyle.Render(line)
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().StringP("format", "f", "text", "Output format: text or json")
+}
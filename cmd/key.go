@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/yechentide/necrack/netease"
+	"github.com/yechentide/necrack/styles"
+)
+
+type keyReport struct {
+	World    string              `json:"world"`
+	Key      string              `json:"key"`
+	Base64   string              `json:"key_base64"`
+	Strategy string              `json:"strategy"`
+	Source   string              `json:"source,omitempty"`
+	Verified bool                `json:"verified"`
+	Files    []netease.FileCheck `json:"files"`
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key [world directory]",
+	Short: "Derive, print and verify the key of a NetEase world",
+	Long: `Derive the encryption key of a NetEase Minecraft world, print it and verify
+it against every encrypted file in the world's 'db' directory.
+
+The printed key can be passed to the encode command to re-encrypt edited files.
+Use --key to verify a known key instead.
+
+Example:
+  necrack key ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5
+  necrack key ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --format json
+  necrack key ./ne-worlds/661428f7-1e29-47ca-99af-c1eac0c41ba5 --save world.key`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		worldDir := args[0]
+		format, _ := cmd.Flags().GetString("format")
+		savePath, _ := cmd.Flags().GetString("save")
+		keyHex, _ := cmd.Flags().GetString("key")
+
+		// Setup logger
+		logger := log.NewWithOptions(nil, log.Options{
+			ReportTimestamp: true,
+			TimeFormat:      "15:04:05",
+			Prefix:          "[key]",
+		})
+
+		if format != "hex" && format != "base64" && format != "json" {
+			logger.Error("Invalid output format", "format", format)
+			fmt.Fprintf(os.Stderr, "❌ Error: Unknown format '%s', expected hex, base64 or json\n", format)
+			os.Exit(exitUsage)
+		}
+
+		dbDir := filepath.Join(worldDir, "db")
+		if _, err := os.Stat(dbDir); os.IsNotExist(err) {
+			logger.Error("db directory does not exist", "world_dir", worldDir)
+			fmt.Fprintf(os.Stderr, "❌ Error: db directory not found in '%s'\n", worldDir)
+			os.Exit(exitFailure)
+		}
+
+		report := keyReport{World: worldDir, Strategy: "provided"}
+
+		var key []byte
+		if keyHex != "" {
+			var err error
+			key, err = parseKeyFlag(keyHex)
+			if err != nil {
+				logger.Error("Invalid key format", "key_hex", keyHex, "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error: Invalid key format: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		} else {
+			derived, err := netease.DeriveKeyWithStrategy(dbDir)
+			if err != nil {
+				logger.Error("Key derivation failed", "world_dir", worldDir, "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			key = derived.Key
+			report.Strategy = string(derived.Strategy)
+			report.Source = derived.Source
+			logger.Info("Key derived", "strategy", derived.Strategy, "source", derived.Source)
+		}
+
+		report.Key = hex.EncodeToString(key)
+		report.Base64 = base64.StdEncoding.EncodeToString(key)
+
+		checks, err := netease.VerifyKey(dbDir, key)
+		if err != nil {
+			logger.Error("Key verification failed", "world_dir", worldDir, "error", err)
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		counts := netease.CountChecks(checks)
+		report.Files = checks
+		report.Verified = counts[netease.CheckFailed] == 0 && counts[netease.CheckPassed]+counts[netease.CheckWarning] > 0
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(report)
+		} else {
+			printKeyReport(report, format, counts)
+		}
+
+		if !report.Verified {
+			if savePath != "" {
+				logger.Error("Not saving a key that failed verification", "path", savePath)
+			}
+			os.Exit(exitKeyMismatch)
+		}
+
+		if savePath != "" {
+			if err := os.WriteFile(savePath, []byte(report.Key+"\n"), 0600); err != nil {
+				logger.Error("Failed to save key", "path", savePath, "error", err)
+				fmt.Fprintf(os.Stderr, "❌ Error saving key: %v\n", err)
+				os.Exit(exitFailure)
+			}
+			logger.Info("Key saved", "path", savePath)
+		}
+	},
+}
+
+func printKeyReport(report keyReport, format string, counts map[netease.CheckStatus]int) {
+	fmt.Println(styles.HeaderStyle.Render("🔑 NetEase World Key"))
+	fmt.Printf("World:    %s\n", styles.PathStyle.Render(report.World))
+
+	key := report.Key
+	if format == "base64" {
+		key = report.Base64
+	}
+	fmt.Printf("Key:      %s\n", styles.KeyStyle.Render(key))
+	if report.Source != "" {
+		fmt.Printf("Strategy: %s (%s)\n", report.Strategy, report.Source)
+	} else {
+		fmt.Printf("Strategy: %s\n", report.Strategy)
+	}
+	fmt.Println()
+
+	for _, check := range report.Files {
+		if check.Status == netease.CheckSkipped {
+			continue
+		}
+		line := fmt.Sprintf("  %-10s %s", check.Status, check.Name)
+		if check.Detail != "" {
+			line += styles.MutedStyle.Render(" - " + check.Detail)
+		}
+		if check.Status == netease.CheckFailed {
+			line = styles.ErrorStyle.Render(line)
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+
+	if report.Verified {
+		fmt.Println(styles.SuccessStyle.Render(fmt.Sprintf("✅ Key verified against %d files", counts[netease.CheckPassed]+counts[netease.CheckWarning])))
+	} else {
+		fmt.Fprintln(os.Stderr, styles.ErrorStyle.Render(fmt.Sprintf("❌ Key verification failed for %d files", counts[netease.CheckFailed])))
+	}
+}
+
+// parseKeyFlag parses a hex key of the size of any registered codec.
+func parseKeyFlag(keyHex string) ([]byte, error) {
+	for _, codec := range netease.Codecs() {
+		if len(keyHex) == codec.KeySize()*2 {
+			return netease.ParseCodecHexKey(codec, keyHex)
+		}
+	}
+	return netease.ParseHexKey(keyHex)
+}
+
+// lookupCodec returns the codec named by a --codec flag. Codecs that only
+// detect their files, like the legacy one, are refused before any key is
+// generated.
+func lookupCodec(name string) (netease.Codec, error) {
+	if codec, ok := netease.LookupCodec(name); ok {
+		if _, err := codec.NewEncrypter(make([]byte, codec.KeySize())); err != nil {
+			return nil, err
+		}
+		return codec, nil
+	}
+
+	var names []string
+	for _, codec := range netease.Codecs() {
+		names = append(names, codec.Name())
+	}
+	return nil, fmt.Errorf("unknown codec %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.Flags().StringP("format", "f", "hex", "Output format: hex, base64 or json")
+	keyCmd.Flags().StringP("save", "s", "", "Write the hex key to this file once it is verified")
+	keyCmd.Flags().StringP("key", "k", "", "Verify this hex key instead of deriving one")
+}
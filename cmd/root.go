@@ -15,10 +15,15 @@ It provides functionality to decrypt and encrypt NetEase Minecraft world databas
 allowing you to work with world data that uses NetEase's custom encryption format.
 
 Available commands:
-  decode    Decrypt NetEase Minecraft world files
-  encode    Encrypt files using NetEase format
+  decode        Decrypt NetEase Minecraft world files
+  encode        Encrypt files using NetEase format
+  encode-world  Encrypt a vanilla Bedrock world using NetEase format
+  key           Derive, print and verify the key of a NetEase world
+  inspect       Report the encryption state of worlds and their files
 
-Use "necrack help [command]" for more information about a specific command.`,
+Use "necrack help [command]" for more information about a specific command.
+
+` + exitCodesHelp,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
@@ -27,9 +32,11 @@ Use "necrack help [command]" for more information about a specific command.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// Commands exit on their own failures, so errors here are command
+	// line errors.
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
@@ -1,8 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
@@ -14,18 +24,129 @@ var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start HTTP server for ZIP file processing",
 	Long: `Start an HTTP server that accepts ZIP file uploads containing NetEase Minecraft worlds,
-decrypts them, and returns the processed files as a ZIP download.
+decrypts them, and returns the processed files as a ZIP download. Vanilla Bedrock
+worlds can be encrypted into the NetEase format the same way.
 
-The server provides a single endpoint:
-  POST /decrypt - Upload a ZIP file and receive the decrypted version
+The server provides the following endpoints:
+  POST /decrypt           - Upload a ZIP file and receive the decrypted version
+  POST /encrypt           - Upload a ZIP file of vanilla worlds and receive the encrypted version
+  POST /jobs              - Upload a ZIP file and decrypt it in the background
+  GET  /jobs/{id}         - Report the status and progress of a job
+  GET  /jobs/{id}/result  - Download the decrypted ZIP file of a finished job
+  GET  /openapi.json      - OpenAPI description of the endpoints
+
+Errors are JSON objects of the form {"error": {"code": "...", "message": "..."}}
+with machine-readable codes such as INVALID_ARCHIVE, NO_WORLDS,
+UNSUPPORTED_LEGACY or KEY_DERIVATION_FAILED.
+
+Uploads are either multipart forms with a "zipfile" field or raw bodies with
+Content-Type application/zip. Bodies larger than --max-upload-mb are rejected
+with 413, as are archives that exceed the extraction limits. Archives with
+paths outside the upload, symlinks or device entries are rejected with 400.
+
+Large uploads should use the job endpoints, which do not keep the request
+open while the worlds are decrypted. Finished jobs are removed after --job-ttl.
+
+The mode query parameter selects what the returned ZIP file contains:
+  decrypted        - Only the decrypted worlds under their original paths (default)
+  original-layout  - Every uploaded file, with each world replaced by its decrypted version
+  both             - Every uploaded file plus a decrypted copy next to each world;
+                     a world at the root of the upload is moved to world/
+
+/encrypt accepts the same uploads, limits and modes, with "encrypted" in place
+of "decrypted". The codec query parameter selects the format (netease, the
+default) and the key query parameter selects the key:
+  (omitted)         - A random key for every world
+  1a2b3c4d5e6f7a8b  - The given hex key for every world
+  original          - The key of each world listed in the report.json of the upload,
+                      so an archive returned by /decrypt can be encrypted again
+The key of every world is listed in report.json.
+
+Every decrypted file is checked against the LevelDB format. Worlds that fail
+to decrypt or fail the check are left out and listed in the report.json file
+of the returned ZIP file, together with the header types found in every world.
+The X-Necrack-Failed-Worlds response header counts them; X-Necrack-Verified
+and X-Necrack-Verify-Warnings report the outcome of the check. Uploads where
+no world could be decrypted are rejected, with 422 when the check failed.
+
+Use --tls-cert and --tls-key to serve HTTPS, or --tls-self-signed to test with
+a generated certificate (curl needs --insecure to accept it).
+
+With --auth-tokens or --auth-users, every endpoint except /health,
+/openapi.json and the landing page requires credentials: a token sent as
+"Authorization: Bearer <token>", or basic auth. Both files list one client per
+line as "<name> <secret> [quota]"; the secret may be written as
+sha256:<hex digest>, and lines starting with # are ignored. The quota limits
+the /decrypt, /encrypt and POST /jobs requests of a client per --quota-window;
+further requests are rejected with 429 until the window ends. Missing or wrong
+credentials are rejected with 401. Jobs are only visible to the client that
+created them.
+
+Uploads to /decrypt, /encrypt and POST /jobs are limited in three ways, each
+answered with a Retry-After header:
+  --rate-limit, --rate-burst  - Token bucket per client IP, 429 RATE_LIMITED
+  --max-concurrent            - Uploads processed at once, 503 SERVER_BUSY
+  --min-free-disk-mb          - Free disk space kept after an upload, 503 INSUFFICIENT_STORAGE
+A full job queue is answered with 503 QUEUE_FULL.
+
+The server stops on SIGINT or SIGTERM. It stops accepting connections and
+jobs, waits up to --shutdown-timeout for running requests and for running and
+queued jobs to finish, then cancels the remaining ones. Temporary files are
+removed once every request and job has returned. Jobs do not survive a
+restart, so their results must be downloaded before.
+
+Timeouts of 0 disable the respective limit. --read-timeout and --write-timeout
+cover whole requests, including uploads and the processing of /decrypt and
+/encrypt, so they must allow for the largest expected worlds.
 
 Example:
   necrack server --port 8080
+  necrack server --bind 127.0.0.1 --shutdown-timeout 2m
+  necrack server --tls-cert cert.pem --tls-key key.pem --auth-tokens tokens.txt
 
   # Upload and decrypt a ZIP file using curl:
-  curl -X POST -F "zipfile=@world.zip" http://localhost:8080/decrypt -o decrypted.zip`,
+  curl -X POST -F "zipfile=@world.zip" http://localhost:8080/decrypt -o decrypted.zip
+
+  # Upload the ZIP file as the raw request body:
+  curl -X POST -H "Content-Type: application/zip" --data-binary @world.zip \
+    "http://localhost:8080/decrypt?filename=world.zip" -o decrypted.zip
+
+  # Encrypt the worlds again with their original keys:
+  curl -X POST -F "zipfile=@decrypted.zip" "http://localhost:8080/encrypt?key=original" -o encrypted.zip
+
+  # Authenticate with an API token:
+  curl -H "Authorization: Bearer <token>" -F "zipfile=@world.zip" https://localhost:8080/decrypt -o decrypted.zip
+
+  # Decrypt in the background:
+  curl -X POST -F "zipfile=@world.zip" http://localhost:8080/jobs
+  curl http://localhost:8080/jobs/<id>
+  curl http://localhost:8080/jobs/<id>/result -o decrypted.zip`,
 	Run: func(cmd *cobra.Command, args []string) {
 		port, _ := cmd.Flags().GetInt("port")
+		bind, _ := cmd.Flags().GetString("bind")
+		readHeaderTimeout, _ := cmd.Flags().GetDuration("read-header-timeout")
+		readTimeout, _ := cmd.Flags().GetDuration("read-timeout")
+		writeTimeout, _ := cmd.Flags().GetDuration("write-timeout")
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		tlsSelfSigned, _ := cmd.Flags().GetBool("tls-self-signed")
+		authTokens, _ := cmd.Flags().GetString("auth-tokens")
+		authUsers, _ := cmd.Flags().GetString("auth-users")
+		quotaWindow, _ := cmd.Flags().GetDuration("quota-window")
+		maxConcurrent, _ := cmd.Flags().GetInt("max-concurrent")
+		rateLimit, _ := cmd.Flags().GetFloat64("rate-limit")
+		rateBurst, _ := cmd.Flags().GetInt("rate-burst")
+		minFreeDiskMB, _ := cmd.Flags().GetInt64("min-free-disk-mb")
+		workers, _ := cmd.Flags().GetInt("workers")
+		queueSize, _ := cmd.Flags().GetInt("queue-size")
+		jobTTL, _ := cmd.Flags().GetDuration("job-ttl")
+		maxUploadMB, _ := cmd.Flags().GetInt64("max-upload-mb")
+		maxExtractMB, _ := cmd.Flags().GetInt64("max-extract-mb")
+		maxExtractFiles, _ := cmd.Flags().GetInt("max-extract-files")
+		maxRatio, _ := cmd.Flags().GetInt("max-compression-ratio")
+		maxDepth, _ := cmd.Flags().GetInt("max-extract-depth")
 		
 		// Setup styled output from centralized styles
 		
@@ -36,17 +157,92 @@ Example:
 			Prefix:          "[necrack-server]",
 		})
 		log.SetDefault(logger)
+
+		if (tlsCert == "") != (tlsKey == "") {
+			logger.Fatal("--tls-cert and --tls-key must be given together")
+		}
+		if tlsSelfSigned && tlsCert != "" {
+			logger.Fatal("--tls-self-signed cannot be combined with --tls-cert")
+		}
+
+		var authenticators []server.Authenticator
+		if authTokens != "" {
+			tokenAuth, err := server.LoadTokenAuth(authTokens)
+			if err != nil {
+				logger.Fatal("Failed to set up authentication", "error", err)
+			}
+			authenticators = append(authenticators, tokenAuth)
+		}
+		if authUsers != "" {
+			basicAuth, err := server.LoadBasicAuth(authUsers)
+			if err != nil {
+				logger.Fatal("Failed to set up authentication", "error", err)
+			}
+			authenticators = append(authenticators, basicAuth)
+		}
+
+		// Without credentials every endpoint stays open.
+		require := func(h http.Handler) http.Handler { return h }
+		metered := require
+		if len(authenticators) > 0 {
+			auth := server.NewAuth(quotaWindow, authenticators...)
+			require, metered = auth.Require, auth.Metered
+		}
+
+		// Uploads are rate limited per client IP first, then bounded by the
+		// global number of slots, then authenticated.
+		limited := metered
+		if maxConcurrent > 0 {
+			concurrency := server.NewConcurrencyLimiter(maxConcurrent)
+			limited = func(h http.Handler) http.Handler { return concurrency.Wrap(metered(h)) }
+		}
+		if rateLimit > 0 {
+			rate := server.NewRateLimiter(rateLimit, rateBurst)
+			inner := limited
+			limited = func(h http.Handler) http.Handler { return rate.Wrap(inner(h)) }
+		}
 		
-		http.HandleFunc("/decrypt", server.DecryptHandler)
+		// Every request works below a directory of its own, so files left
+		// by requests that are still running at exit can be removed.
+		tempDir, err := os.MkdirTemp("", "necrack-server-*")
+		if err != nil {
+			logger.Fatal("Failed to create temp directory", "error", err)
+		}
+		defer func() {
+			if err := os.RemoveAll(tempDir); err != nil {
+				logger.Warn("Failed to clean temp directory", "temp_dir", tempDir, "error", err)
+			}
+		}()
+
+		cfg := server.Config{
+			MaxUploadSize:       maxUploadMB << 20,
+			MaxExtractSize:      maxExtractMB << 20,
+			MaxExtractFiles:     maxExtractFiles,
+			MaxCompressionRatio: maxRatio,
+			MaxExtractDepth:     maxDepth,
+			TempDir:             tempDir,
+			MinFreeDisk:         minFreeDiskMB << 20,
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/decrypt", limited(server.NewDecryptHandler(cfg)))
+		mux.Handle("/encrypt", limited(server.NewEncryptHandler(cfg)))
+
+		jobs := server.NewJobManager(cfg, workers, queueSize, jobTTL)
+		defer jobs.Close()
+		mux.Handle("POST /jobs", limited(http.HandlerFunc(jobs.CreateHandler)))
+		mux.Handle("GET /jobs/{id}", require(http.HandlerFunc(jobs.StatusHandler)))
+		mux.Handle("GET /jobs/{id}/result", require(http.HandlerFunc(jobs.ResultHandler)))
+		mux.HandleFunc("GET /openapi.json", server.OpenAPIHandler)
 		
 		// Health check endpoint
-		http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
 		})
 		
 		// Simple landing page
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path != "/" {
 				http.NotFound(w, r)
 				return
@@ -86,31 +282,161 @@ Example:
 			fmt.Fprintf(w, html, port)
 		})
 
-		addr := fmt.Sprintf(":%d", port)
+		// Requests run below baseCtx, which is canceled once the shutdown
+		// deadline has passed, and are counted so that the temp directory
+		// is only removed after the last one has returned.
+		baseCtx, cancelRequests := context.WithCancel(context.Background())
+		defer cancelRequests()
+		var requests sync.WaitGroup
+
+		addr := net.JoinHostPort(bind, strconv.Itoa(port))
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           trackRequests(mux, &requests),
+			BaseContext:       func(net.Listener) context.Context { return baseCtx },
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+		}
 		
+		displayHost := bind
+		if ip := net.ParseIP(bind); bind == "" || ip != nil && ip.IsUnspecified() {
+			displayHost = "localhost"
+		}
+		scheme := "http"
+		if tlsCert != "" || tlsSelfSigned {
+			scheme = "https"
+		}
+		if tlsSelfSigned {
+			cert, err := server.SelfSignedCertificate([]string{displayHost, "localhost", "127.0.0.1", "::1"})
+			if err != nil {
+				logger.Error("Failed to generate certificate", "error", err)
+				jobs.Close()
+				os.RemoveAll(tempDir)
+				os.Exit(exitFailure)
+			}
+			srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			logger.Warn("Using a self-signed certificate, clients will not trust it")
+		}
+		baseURL := scheme + "://" + net.JoinHostPort(displayHost, strconv.Itoa(port))
+
 		// Display startup information with styling
 		fmt.Println(styles.ServerHeaderStyle.Render("🌍 NetEase World Decryption Server"))
 		fmt.Println()
 		fmt.Printf("%s %s\n", 
 			styles.InfoStyle.Render("⚡ Server starting on:"), 
-			styles.URLStyle.Render(fmt.Sprintf("http://localhost:%d", port)))
+			styles.URLStyle.Render(baseURL))
 		fmt.Printf("%s %s\n", 
 			styles.InfoStyle.Render("📤 Upload endpoint:"), 
-			styles.URLStyle.Render(fmt.Sprintf("http://localhost:%d/decrypt", port)))
+			styles.URLStyle.Render(baseURL+"/decrypt"))
+		fmt.Printf("%s %s\n", 
+			styles.InfoStyle.Render("🔒 Encrypt endpoint:"), 
+			styles.URLStyle.Render(baseURL+"/encrypt"))
+		fmt.Printf("%s %s\n", 
+			styles.InfoStyle.Render("🧵 Job endpoint:"), 
+			styles.URLStyle.Render(baseURL+"/jobs"))
 		fmt.Printf("%s %s\n", 
 			styles.InfoStyle.Render("💚 Health check:"), 
-			styles.URLStyle.Render(fmt.Sprintf("http://localhost:%d/health", port)))
+			styles.URLStyle.Render(baseURL+"/health"))
 		fmt.Println()
 		
-		logger.Info("Server starting", "port", port, "addr", addr)
-		
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			logger.Fatal("Server failed to start", "error", err)
+		logger.Info("Server starting", "port", port, "addr", addr, "workers", workers, "queue_size", queueSize, "job_ttl", jobTTL, "shutdown_timeout", shutdownTimeout, "tls", scheme == "https", "auth", len(authenticators) > 0, "max_concurrent", maxConcurrent, "rate_limit", rateLimit)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		serveErr := make(chan error, 1)
+		go func() {
+			if scheme == "https" {
+				// Empty paths use the self-signed certificate in TLSConfig.
+				serveErr <- srv.ListenAndServeTLS(tlsCert, tlsKey)
+			} else {
+				serveErr <- srv.ListenAndServe()
+			}
+		}()
+
+		select {
+		case err := <-serveErr:
+			// os.Exit skips the deferred cleanup.
+			logger.Error("Server failed to start", "error", err)
+			jobs.Close()
+			os.RemoveAll(tempDir)
+			os.Exit(exitFailure)
+		case <-ctx.Done():
+		}
+		stop()
+
+		logger.Info("Shutting down, waiting for running requests and jobs", "timeout", shutdownTimeout)
+		if err := shutdownServer(srv, jobs, &requests, cancelRequests, shutdownTimeout); err != nil {
+			logger.Warn("Requests or jobs were still running at the deadline and have been canceled", "error", err)
 		}
+		logger.Info("Server stopped")
 	},
 }
 
+// shutdownServer stops srv from accepting connections, then waits up to
+// timeout for active requests and for the jobs of jobs. Requests still
+// running after timeout are canceled with cancelRequests and their
+// connections closed; jobs are canceled by their manager. It returns only
+// once every request counted by requests and every job has returned. A
+// timeout of 0 waits indefinitely.
+func shutdownServer(srv *http.Server, jobs *server.JobManager, requests *sync.WaitGroup, cancelRequests context.CancelFunc, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		cancelRequests()
+		srv.Close()
+	}
+	// Close does not wait for the handlers of the connections it closes.
+	requests.Wait()
+
+	if jobErr := jobs.Shutdown(ctx); err == nil {
+		err = jobErr
+	}
+	return err
+}
+
+// trackRequests counts the requests h is serving in requests.
+func trackRequests(h http.Handler, requests *sync.WaitGroup) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		defer requests.Done()
+		h.ServeHTTP(w, r)
+	})
+}
+
 func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().IntP("port", "p", 8080, "Port to run the server on")
+	serverCmd.Flags().String("bind", "", "Address to listen on (default all interfaces)")
+	serverCmd.Flags().Duration("read-header-timeout", 10*time.Second, "Maximum time to read request headers")
+	serverCmd.Flags().Duration("read-timeout", 10*time.Minute, "Maximum time to read a whole request, including the upload")
+	serverCmd.Flags().Duration("write-timeout", 30*time.Minute, "Maximum time from the end of the request headers to the end of the response")
+	serverCmd.Flags().Duration("idle-timeout", 2*time.Minute, "Maximum time to keep idle connections open")
+	serverCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for running requests and jobs on shutdown")
+	serverCmd.Flags().String("tls-cert", "", "TLS certificate file, enables HTTPS")
+	serverCmd.Flags().String("tls-key", "", "TLS private key file")
+	serverCmd.Flags().Bool("tls-self-signed", false, "Serve HTTPS with a generated self-signed certificate, for testing")
+	serverCmd.Flags().String("auth-tokens", "", "File of API tokens, one \"<name> <token> [quota]\" per line")
+	serverCmd.Flags().String("auth-users", "", "File of basic auth users, one \"<user> <password> [quota]\" per line")
+	serverCmd.Flags().Duration("quota-window", 24*time.Hour, "Period over which the quotas of tokens and users are counted")
+	serverCmd.Flags().Int("max-concurrent", 4, "Maximum number of uploads processed at once (0 for no limit)")
+	serverCmd.Flags().Float64("rate-limit", 1, "Uploads per second allowed for each client IP (0 for no limit)")
+	serverCmd.Flags().Int("rate-burst", 5, "Uploads a client IP may send at once before --rate-limit applies")
+	serverCmd.Flags().Int64("min-free-disk-mb", 1024, "Free disk space in MiB that must remain after an upload (0 to disable)")
+	serverCmd.Flags().Int64("max-upload-mb", server.DefaultMaxUploadSize>>20, "Maximum upload size in MiB")
+	serverCmd.Flags().Int64("max-extract-mb", server.DefaultMaxExtractSize>>20, "Maximum total uncompressed size of an upload in MiB")
+	serverCmd.Flags().Int("max-extract-files", server.DefaultMaxExtractFiles, "Maximum number of entries in an upload")
+	serverCmd.Flags().Int("max-compression-ratio", server.DefaultMaxCompressionRatio, "Maximum compression ratio of a single entry")
+	serverCmd.Flags().Int("max-extract-depth", server.DefaultMaxExtractDepth, "Maximum directory depth of an entry")
+	serverCmd.Flags().Int("workers", 2, "Number of jobs decrypted concurrently")
+	serverCmd.Flags().Int("queue-size", 16, "Number of jobs that may wait for a worker")
+	serverCmd.Flags().Duration("job-ttl", time.Hour, "How long results of finished jobs are kept")
 }
\ No newline at end of file
@@ -0,0 +1,178 @@
+package netease
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// Codec is an encryption scheme of NetEase db files. Encrypted files start
+// with the header of their codec, which is how the codec of a file is
+// detected.
+type Codec interface {
+	// Name identifies the codec in reports and on the command line. It is
+	// also the String of the HeaderType of files encrypted with the codec.
+	Name() string
+
+	// Header is written before the encrypted body of every file. It is
+	// HeaderSize bytes long.
+	Header() []byte
+
+	// KeySize is the length of the keys of the codec in bytes.
+	KeySize() int
+
+	// DeriveKey recovers the key of an encrypted db directory. Codecs whose
+	// keys cannot be recovered return an error wrapping ErrKeyNotDerivable,
+	// so the key has to be supplied.
+	DeriveKey(dbFS fs.FS) (*DerivedKey, error)
+
+	// NewDecrypter returns a stream that decrypts a file body from its start.
+	NewDecrypter(key []byte) (cipher.Stream, error)
+
+	// NewEncrypter returns a stream that encrypts a file body from its start.
+	NewEncrypter(key []byte) (cipher.Stream, error)
+}
+
+// ErrKeyNotDerivable is returned by Codec.DeriveKey when the key cannot be
+// recovered from the files of a world.
+var ErrKeyNotDerivable = errors.New("key cannot be derived")
+
+var (
+	codecsMu sync.RWMutex
+	codecs   []Codec
+	// codecTypes maps the name of every registered codec to the HeaderType
+	// of its files.
+	codecTypes = make(map[string]HeaderType)
+)
+
+// RegisterCodec makes a codec available for detection and by name. It
+// panics if the name or the header is already taken or the header does not
+// have HeaderSize bytes, since the codec of a file would then be ambiguous.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	header := c.Header()
+	if len(header) != HeaderSize {
+		panic(fmt.Sprintf("netease: header of codec %q must be %d bytes", c.Name(), HeaderSize))
+	}
+	if bytes.Equal(header, headerVanillaBedrock) {
+		panic(fmt.Sprintf("netease: header of codec %q matches vanilla files", c.Name()))
+	}
+	switch c.Name() {
+	case HeaderTypeVanillaBedrock.String(), HeaderTypeUnknown.String():
+		panic(fmt.Sprintf("netease: codec name %q is reserved", c.Name()))
+	}
+
+	for _, existing := range codecs {
+		if existing.Name() == c.Name() {
+			panic(fmt.Sprintf("netease: codec %q registered twice", c.Name()))
+		}
+		if bytes.Equal(existing.Header(), header) {
+			panic(fmt.Sprintf("netease: header of codec %q conflicts with codec %q", c.Name(), existing.Name()))
+		}
+	}
+
+	// The built-in codecs keep their constants. Other codecs get the types
+	// after HeaderTypeUnknown, in registration order.
+	headerType := HeaderTypeUnknown + 1 + HeaderType(len(codecs))
+	switch c.Name() {
+	case HeaderTypeNetEaseCurrent.String():
+		headerType = HeaderTypeNetEaseCurrent
+	case HeaderTypeNetEaseLegacy.String():
+		headerType = HeaderTypeNetEaseLegacy
+	}
+
+	codecs = append(codecs, c)
+	codecTypes[c.Name()] = headerType
+}
+
+// Codecs returns the registered codecs in registration order.
+func Codecs() []Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return append([]Codec(nil), codecs...)
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// DetectCodec returns the codec whose header data starts with, or nil if the
+// data is not encrypted with a registered codec.
+func DetectCodec(data []byte) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, c := range codecs {
+		if bytes.HasPrefix(data, c.Header()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// DefaultCodec is used for encryption unless another codec is chosen. It is
+// the format current NetEase clients write.
+var DefaultCodec Codec = xorCodec{}
+
+func init() {
+	RegisterCodec(xorCodec{})
+	RegisterCodec(legacyCodec{})
+}
+
+// xorCodec is the current NetEase format (80 1D 30 01), which XORs the body
+// with a repeating 8-byte key.
+type xorCodec struct{}
+
+func (xorCodec) Name() string   { return HeaderTypeNetEaseCurrent.String() }
+func (xorCodec) Header() []byte { return headerNetEaseCurrent }
+func (xorCodec) KeySize() int   { return KeySize }
+
+func (xorCodec) DeriveKey(dbFS fs.FS) (*DerivedKey, error) {
+	return deriveXORKey(dbFS)
+}
+
+func (c xorCodec) NewDecrypter(key []byte) (cipher.Stream, error) {
+	return c.NewEncrypter(key)
+}
+
+func (xorCodec) NewEncrypter(key []byte) (cipher.Stream, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be exactly %d bytes, got %d bytes", KeySize, len(key))
+	}
+	return NewXORStream(key), nil
+}
+
+// legacyCodec is the legacy NetEase format (90 1D 30 01). It is registered
+// so that legacy files are detected, but none of its operations are
+// supported; see errLegacyCipher.
+type legacyCodec struct{}
+
+func (legacyCodec) Name() string   { return HeaderTypeNetEaseLegacy.String() }
+func (legacyCodec) Header() []byte { return headerNetEaseLegacy }
+func (legacyCodec) KeySize() int   { return LegacyKeySize }
+
+func (legacyCodec) DeriveKey(fs.FS) (*DerivedKey, error) {
+	return nil, errLegacyCipher
+}
+
+func (legacyCodec) NewDecrypter([]byte) (cipher.Stream, error) {
+	return nil, errLegacyCipher
+}
+
+func (legacyCodec) NewEncrypter([]byte) (cipher.Stream, error) {
+	return nil, errLegacyCipher
+}
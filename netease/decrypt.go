@@ -1,90 +1,160 @@
 package netease
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 func DecryptFile(filePath string, key []byte) ([]byte, error) {
-	data, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+		return nil, &FileError{Path: filePath, Err: err}
 	}
+	defer file.Close()
 
-	if err := ValidateDecryptableFile(data); err != nil {
-		return nil, fmt.Errorf("file %s is not decryptable: %w", filePath, err)
+	reader, err := NewDecryptReader(file, key)
+	if err != nil {
+		return nil, &FileError{Path: filePath, Err: fmt.Errorf("not decryptable: %w", err)}
 	}
 
-	body := data[4:]
-	decrypted := xorDecrypt(body, key)
+	decrypted, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, &FileError{Path: filePath, Err: fmt.Errorf("failed to decrypt: %w", err)}
+	}
 
 	return decrypted, nil
 }
 
 func DecryptWorldDB(worldDir string) (string, error) {
+	return DecryptWorldDBWithOptions(worldDir, WorldOptions{})
+}
+
+// DecryptWorldDBWithOptions decrypts a world and returns the directory that
+// holds the result. The key is derived from the world unless opts.Key is set.
+func DecryptWorldDBWithOptions(worldDir string, opts WorldOptions) (string, error) {
+	if err := opts.context().Err(); err != nil {
+		return "", err
+	}
+
 	dbDir := filepath.Join(worldDir, "db")
 	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
 		return "", fmt.Errorf("db directory not found in %s", worldDir)
 	}
 
-	// Create a copy of the world directory
-	timestamp := time.Now().Format("20060102_150405")
-	worldDirName := filepath.Base(worldDir)
-	copyDir := filepath.Join(filepath.Dir(worldDir), worldDirName+"_decrypted_"+timestamp)
-	
-	if err := copyDirectory(worldDir, copyDir); err != nil {
-		return "", fmt.Errorf("failed to copy world directory: %w", err)
+	if err := requireEncryptedFiles(dbDir); err != nil {
+		return "", err
 	}
 
-	// Work on the copied directory
-	copyDbDir := filepath.Join(copyDir, "db")
-	key, err := DeriveKey(copyDbDir)
+	key, err := resolveWorldKey(dbDir, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to derive key: %w", err)
+		return "", err
 	}
 
-	err = filepath.WalkDir(copyDbDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	staged, err := prepareWorkDir(worldDir, "decrypted", opts)
+	if err != nil {
+		return "", err
+	}
 
-		if d.IsDir() {
-			return nil
-		}
+	opts.Progress = staged.progress(opts.Progress)
+
+	// Work on the staged copy
+	copyDbDir := filepath.Join(staged.Dir, "db")
 
-		data, err := os.ReadFile(path)
+	files, err := listFiles(copyDbDir)
+	if err != nil {
+		staged.rollback(opts)
+		return "", fmt.Errorf("failed to process db directory: %w", err)
+	}
+
+	err = processFiles(files, opts, func(path string) (bool, error) {
+		headerType, err := readHeaderType(path)
 		if err != nil {
-			return fmt.Errorf("failed to read file %s: %w", path, err)
+			return false, err
 		}
 
-		headerType := identifyHeader(data)
-		if headerType != HeaderTypeNetEaseCurrent {
-			return nil
+		if !headerType.Encrypted() {
+			return false, nil
 		}
 
-		decrypted, err := DecryptFile(path, key)
-		if err != nil {
-			return fmt.Errorf("failed to decrypt file %s: %w", path, err)
+		// Overwrite the original file in the copy with decrypted data
+		if err := decryptFileInPlace(path, key); err != nil {
+			return false, err
 		}
 
-		// Overwrite the original file in the copy with decrypted data
-		if err := os.WriteFile(path, decrypted, 0644); err != nil {
-			return fmt.Errorf("failed to write decrypted file %s: %w", path, err)
+		opts.logger().Debug("Decrypted file", "path", path)
+
+		if opts.Verify {
+			if err := verifyDecryptedFile(path, opts); err != nil {
+				return false, err
+			}
 		}
 
-		fmt.Printf("Decrypted: %s\n", path)
-		return nil
+		return true, nil
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to process db directory: %w", err)
+		staged.rollback(opts)
+		return "", fmt.Errorf("failed to process db directory: %w", staged.sourcePaths(err))
 	}
 
-	return copyDir, nil
+	return staged.commit(opts)
+}
+
+// requireEncryptedFiles returns an error if no file of dbDir is encrypted.
+// It wraps ErrVanillaWorld if CURRENT is plain, and ErrUnknownHeader if it
+// is not recognized either.
+func requireEncryptedFiles(dbDir string) error {
+	counts, err := CountHeaderTypes(dbDir)
+	if err != nil {
+		return err
+	}
+	for headerType := range counts {
+		if headerType.Encrypted() {
+			return nil
+		}
+	}
+
+	currentPath := filepath.Join(dbDir, "CURRENT")
+	headerType, err := readHeaderType(currentPath)
+	if err != nil {
+		return err
+	}
+	if headerType != HeaderTypeVanillaBedrock {
+		return &FileError{Path: currentPath, Err: ErrUnknownHeader}
+	}
+	return fmt.Errorf("%w: no file in %s is encrypted", ErrVanillaWorld, dbDir)
+}
+
+func resolveWorldKey(dbDir string, opts WorldOptions) ([]byte, error) {
+	dbFS := os.DirFS(dbDir)
+	codec := worldCodec(dbFS)
+
+	if opts.Key != nil {
+		// A wrong key is caught before any file is written.
+		err := verifyCurrentKey(dbFS, codec, opts.Key)
+		if errors.Is(err, ErrLegacyEncryption) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s key: %w", codec.Name(), err)
+		}
+		return opts.Key, nil
+	}
+
+	derived, err := codec.DeriveKey(dbFS)
+	if errors.Is(err, ErrKeyNotDerivable) {
+		return nil, fmt.Errorf("%s world requires a %d-byte key: %w", codec.Name(), codec.KeySize(), err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	opts.logger().Info("Derived key", "strategy", derived.Strategy, "source", derived.Source)
+	return derived.Key, nil
 }
 
 func copyDirectory(src, dst string) error {
@@ -130,21 +200,22 @@ func copyDirectory(src, dst string) error {
 			return fmt.Errorf("failed to copy file content: %w", err)
 		}
 
+		if err := dstFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", dstPath, err)
+		}
+
 		return os.Chmod(dstPath, srcInfo.Mode())
 	})
 }
 
+// xorDecrypt decrypts a body of the current format.
 func xorDecrypt(data []byte, key []byte) []byte {
 	if len(key) == 0 {
 		return data
 	}
 
 	result := make([]byte, len(data))
-	keyLen := len(key)
-
-	for i := 0; i < len(data); i++ {
-		result[i] = data[i] ^ key[i%keyLen]
-	}
+	NewXORStream(key).XORKeyStream(result, data)
 
 	return result
 }
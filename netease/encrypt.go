@@ -1,31 +1,143 @@
 package netease
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func EncryptFile(filePath string, key []byte) ([]byte, error) {
+	return EncryptFileWithCodec(filePath, DefaultCodec, key)
+}
+
+// EncryptFileWithCodec returns the content of filePath encrypted with codec.
+func EncryptFileWithCodec(filePath string, codec Codec, key []byte) ([]byte, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
 	}
-	result := encryptData(data, key)
-	return result, nil
+
+	var result bytes.Buffer
+	writer, err := NewEncryptWriterWithCodec(&result, codec, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt file %s: %w", filePath, err)
+	}
+	return result.Bytes(), nil
 }
 
-func encryptData(data []byte, key []byte) []byte {
-	encrypted := xorDecrypt(data, key)
+// EncryptWorldDB encrypts every LevelDB file of a vanilla Bedrock world with
+// key and returns the path of the encrypted copy.
+func EncryptWorldDB(worldDir string, key []byte) (string, error) {
+	return EncryptWorldDBWithOptions(worldDir, WorldOptions{Key: key})
+}
+
+// EncryptWorldDBWithOptions is like EncryptWorldDB but takes the key, the
+// codec and the output settings from opts.
+func EncryptWorldDBWithOptions(worldDir string, opts WorldOptions) (string, error) {
+	if err := opts.context().Err(); err != nil {
+		return "", err
+	}
+
+	codec := opts.codec()
+	key := opts.Key
+	if len(key) != codec.KeySize() {
+		return "", fmt.Errorf("%s key must be exactly %d bytes, got %d bytes", codec.Name(), codec.KeySize(), len(key))
+	}
+
+	dbDir := filepath.Join(worldDir, "db")
+	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
+		return "", fmt.Errorf("db directory not found in %s", worldDir)
+	}
 
-	result := make([]byte, 0, len(headerNetEaseCurrent)+len(encrypted))
-	result = append(result, headerNetEaseCurrent...)
-	result = append(result, encrypted...)
+	currentData, err := os.ReadFile(filepath.Join(dbDir, "CURRENT"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read CURRENT file: %w", err)
+	}
+	switch headerType := identifyHeader(currentData); {
+	case headerType == HeaderTypeVanillaBedrock:
+	case headerType.Encrypted():
+		return "", fmt.Errorf("world is already encrypted with the %s codec", headerType)
+	default:
+		return "", &FileError{Path: filepath.Join(dbDir, "CURRENT"), Err: ErrUnknownHeader}
+	}
 
-	return result
+	staged, err := prepareWorkDir(worldDir, "encrypted", opts)
+	if err != nil {
+		return "", err
+	}
+
+	opts.Progress = staged.progress(opts.Progress)
+
+	copyDbDir := filepath.Join(staged.Dir, "db")
+	files, err := listFiles(copyDbDir)
+	if err != nil {
+		staged.rollback(opts)
+		return "", fmt.Errorf("failed to process db directory: %w", err)
+	}
+
+	err = processFiles(files, opts, func(path string) (bool, error) {
+		if !isEncryptableFile(filepath.Base(path)) {
+			return false, nil
+		}
+
+		if err := encryptFileInPlace(path, codec, key); err != nil {
+			return false, err
+		}
+
+		opts.logger().Debug("Encrypted file", "path", path)
+		return true, nil
+	})
+
+	if err != nil {
+		staged.rollback(opts)
+		return "", fmt.Errorf("failed to process db directory: %w", staged.sourcePaths(err))
+	}
+
+	return staged.commit(opts)
+}
+
+// isEncryptableFile reports whether NetEase clients expect the db file to be
+// encrypted. LOCK and the LOG text files are left untouched.
+func isEncryptableFile(name string) bool {
+	if name == "CURRENT" || strings.HasPrefix(name, "MANIFEST-") {
+		return true
+	}
+
+	ext := filepath.Ext(name)
+	return ext == ".ldb" || ext == ".log"
+}
+
+// GenerateKey returns a random key for DefaultCodec.
+func GenerateKey() ([]byte, error) {
+	return GenerateCodecKey(DefaultCodec)
+}
+
+// GenerateCodecKey returns a random key for codec.
+func GenerateCodecKey(codec Codec) ([]byte, error) {
+	key := make([]byte, codec.KeySize())
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
 }
 
 func ParseHexKey(keyHex string) ([]byte, error) {
+	return ParseCodecHexKey(DefaultCodec, keyHex)
+}
+
+// ParseCodecHexKey parses a hex key of the size codec requires.
+func ParseCodecHexKey(codec Codec, keyHex string) ([]byte, error) {
+	return parseHexKey(keyHex, codec.KeySize())
+}
+
+func parseHexKey(keyHex string, size int) ([]byte, error) {
 	key, err := hex.DecodeString(keyHex)
 	if err != nil {
 		return nil, fmt.Errorf("invalid hex string: %w", err)
@@ -35,8 +147,8 @@ func ParseHexKey(keyHex string) ([]byte, error) {
 		return nil, fmt.Errorf("key cannot be empty")
 	}
 
-	if len(key) != 8 {
-		return nil, fmt.Errorf("key must be exactly 8 bytes (16 hex characters), got %d bytes", len(key))
+	if len(key) != size {
+		return nil, fmt.Errorf("key must be exactly %d bytes (%d hex characters), got %d bytes", size, size*2, len(key))
 	}
 
 	return key, nil
@@ -0,0 +1,45 @@
+package netease
+
+import "errors"
+
+// Errors returned by the package are wrapped with details, so test for them
+// with errors.Is.
+var (
+	// ErrLegacyEncryption means the world uses the legacy NetEase format,
+	// which cannot be decrypted or written.
+	ErrLegacyEncryption = errors.New("legacy NetEase encryption")
+
+	// ErrVanillaWorld means the world or file is not encrypted.
+	ErrVanillaWorld = errors.New("vanilla Bedrock world")
+
+	// ErrNoManifest means the db directory has no MANIFEST file to derive
+	// the key from.
+	ErrNoManifest = errors.New("no MANIFEST file")
+
+	// ErrKeyMismatch means a key does not decrypt the world.
+	ErrKeyMismatch = errors.New("key does not match")
+
+	// ErrCorrupted means a decrypted file is not valid LevelDB data although
+	// CURRENT and the MANIFEST decrypt correctly, so the file itself is
+	// damaged.
+	ErrCorrupted = errors.New("corrupted file")
+
+	// ErrUnknownHeader means a file starts with neither the header of a
+	// registered codec nor the vanilla one.
+	ErrUnknownHeader = errors.New("unknown header")
+)
+
+// FileError records the db file an operation failed on. Use errors.As to get
+// the path.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
@@ -11,6 +11,8 @@ var (
 	headerVanillaBedrock = []byte{0x4D, 0x41, 0x4E, 0x49} // "MANI"
 )
 
+// HeaderType classifies a db file by its first bytes. Files of a Codec
+// registered beyond the built-in ones have a type after HeaderTypeUnknown.
 type HeaderType int
 
 const (
@@ -20,37 +22,110 @@ const (
 	HeaderTypeUnknown
 )
 
-func identifyHeader(data []byte) HeaderType {
-	if len(data) < 4 {
-		return HeaderTypeUnknown
+// String returns the name used for t in reports. Encrypted types are named
+// after their codec.
+func (t HeaderType) String() string {
+	switch t {
+	case HeaderTypeNetEaseCurrent:
+		return "netease"
+	case HeaderTypeNetEaseLegacy:
+		return "netease-legacy"
+	case HeaderTypeVanillaBedrock:
+		return "vanilla"
+	case HeaderTypeUnknown:
+		return "unknown"
 	}
+	if c := t.Codec(); c != nil {
+		return c.Name()
+	}
+	return "unknown"
+}
 
-	header := data[:4]
+// MarshalText encodes t as its String form.
+func (t HeaderType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
 
-	if bytes.Equal(header, headerNetEaseCurrent) {
-		return HeaderTypeNetEaseCurrent
+// UnmarshalText decodes the String form of a header type.
+func (t *HeaderType) UnmarshalText(text []byte) error {
+	switch name := string(text); name {
+	case HeaderTypeVanillaBedrock.String():
+		*t = HeaderTypeVanillaBedrock
+	case HeaderTypeUnknown.String():
+		*t = HeaderTypeUnknown
+	default:
+		codecsMu.RLock()
+		defer codecsMu.RUnlock()
+		headerType, ok := codecTypes[name]
+		if !ok {
+			return fmt.Errorf("unknown header type %q", name)
+		}
+		*t = headerType
 	}
-	if bytes.Equal(header, headerNetEaseLegacy) {
-		return HeaderTypeNetEaseLegacy
+	return nil
+}
+
+// Codec returns the codec of files of type t, or nil if they are not
+// encrypted.
+func (t HeaderType) Codec() Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, c := range codecs {
+		if codecTypes[c.Name()] == t {
+			return c
+		}
+	}
+	return nil
+}
+
+// Encrypted reports whether files of type t are encrypted with a registered
+// codec.
+func (t HeaderType) Encrypted() bool {
+	return t.Codec() != nil
+}
+
+func identifyHeader(data []byte) HeaderType {
+	if c := DetectCodec(data); c != nil {
+		codecsMu.RLock()
+		defer codecsMu.RUnlock()
+		return codecTypes[c.Name()]
 	}
-	if bytes.Equal(header, headerVanillaBedrock) {
+	if bytes.HasPrefix(data, headerVanillaBedrock) {
 		return HeaderTypeVanillaBedrock
 	}
-
 	return HeaderTypeUnknown
 }
 
+// ValidateDecryptableFile checks that data starts with the header of a
+// registered codec. Otherwise it returns an error wrapping ErrVanillaWorld or
+// ErrUnknownHeader.
 func ValidateDecryptableFile(data []byte) error {
-	headerType := identifyHeader(data)
-
-	switch headerType {
-	case HeaderTypeNetEaseCurrent:
+	if DetectCodec(data) != nil {
 		return nil
-	case HeaderTypeNetEaseLegacy:
-		return fmt.Errorf("legacy NetEase encryption (AES-CFB8) is not supported")
-	case HeaderTypeVanillaBedrock:
-		return fmt.Errorf("vanilla Bedrock MANIFEST format, no decryption needed")
-	default:
-		return fmt.Errorf("unknown or invalid header format")
 	}
+	if identifyHeader(data) == HeaderTypeVanillaBedrock {
+		return fmt.Errorf("%w: MANIFEST format, no decryption needed", ErrVanillaWorld)
+	}
+	return fmt.Errorf("%w % X", ErrUnknownHeader, data[:min(len(data), HeaderSize)])
+}
+
+// CountHeaderTypes returns how many files of a db directory start with each
+// header type.
+func CountHeaderTypes(dbDir string) (map[HeaderType]int, error) {
+	files, err := listFiles(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read db directory: %w", err)
+	}
+
+	counts := make(map[HeaderType]int)
+	for _, path := range files {
+		headerType, err := readHeaderType(path)
+		if err != nil {
+			return nil, err
+		}
+		counts[headerType]++
+	}
+
+	return counts, nil
 }
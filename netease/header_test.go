@@ -0,0 +1,95 @@
+package netease
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"testing"
+)
+
+// otherCodec is a third-party codec, registered by registerOtherCodec.
+type otherCodec struct{ xorCodec }
+
+func (otherCodec) Name() string   { return "other" }
+func (otherCodec) Header() []byte { return []byte{0xA0, 0x1D, 0x30, 0x01} }
+
+// registerOtherCodec registers otherCodec until the end of the test.
+func registerOtherCodec(t *testing.T) {
+	t.Helper()
+	codecsMu.RLock()
+	saved, savedTypes := codecs, maps.Clone(codecTypes)
+	codecsMu.RUnlock()
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		defer codecsMu.Unlock()
+		codecs, codecTypes = saved, savedTypes
+	})
+	RegisterCodec(otherCodec{})
+}
+
+func TestIdentifyHeader(t *testing.T) {
+	registerOtherCodec(t)
+
+	for _, tt := range []struct {
+		data      []byte
+		want      HeaderType
+		name      string
+		encrypted bool
+	}{
+		{data: headerNetEaseCurrent, want: HeaderTypeNetEaseCurrent, name: "netease", encrypted: true},
+		{data: headerNetEaseLegacy, want: HeaderTypeNetEaseLegacy, name: "netease-legacy", encrypted: true},
+		{data: headerVanillaBedrock, want: HeaderTypeVanillaBedrock, name: "vanilla"},
+		{data: []byte{0x00, 0x01}, want: HeaderTypeUnknown, name: "unknown"},
+		{data: otherCodec{}.Header(), want: HeaderTypeUnknown + 1 + 2, name: "other", encrypted: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := identifyHeader(append(bytes.Clone(tt.data), "body"...))
+			if got != tt.want {
+				t.Errorf("identifyHeader = %d, want %d", got, tt.want)
+			}
+			if got.String() != tt.name {
+				t.Errorf("String = %q, want %q", got, tt.name)
+			}
+			if got.Encrypted() != tt.encrypted {
+				t.Errorf("Encrypted = %v, want %v", got.Encrypted(), tt.encrypted)
+			}
+			if tt.encrypted && got.Codec().Name() != tt.name {
+				t.Errorf("Codec = %s, want %s", got.Codec().Name(), tt.name)
+			}
+		})
+	}
+}
+
+func TestHeaderTypeJSON(t *testing.T) {
+	registerOtherCodec(t)
+	other := identifyHeader(otherCodec{}.Header())
+
+	counts := map[HeaderType]int{
+		HeaderTypeNetEaseCurrent: 3,
+		HeaderTypeNetEaseLegacy:  2,
+		HeaderTypeVanillaBedrock: 1,
+		HeaderTypeUnknown:        4,
+		other:                    5,
+	}
+	data, err := json.Marshal(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"netease":3,"netease-legacy":2,"other":5,"unknown":4,"vanilla":1}`
+	if string(data) != want {
+		t.Errorf("JSON = %s, want %s", data, want)
+	}
+
+	var decoded map[HeaderType]int
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(decoded, counts) {
+		t.Errorf("decoded %v, want %v", decoded, counts)
+	}
+
+	var headerType HeaderType
+	if err := headerType.UnmarshalText([]byte("rot13")); err == nil {
+		t.Error("unknown name decoded without an error")
+	}
+}
@@ -0,0 +1,195 @@
+package netease
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/yechentide/necrack/archive"
+)
+
+// Verdict summarizes the encryption state of a world.
+type Verdict string
+
+const (
+	// VerdictEncrypted means every LevelDB file is encrypted and decrypts
+	// to valid data.
+	VerdictEncrypted Verdict = "encrypted"
+	// VerdictMixed means only some LevelDB files are encrypted.
+	VerdictMixed Verdict = "mixed"
+	// VerdictVanilla means no file is encrypted.
+	VerdictVanilla Verdict = "vanilla"
+	// VerdictCorrupted means at least one file is not valid LevelDB data,
+	// before or after decryption.
+	VerdictCorrupted Verdict = "corrupted"
+)
+
+// FileReport describes a single db file of an inspected world.
+type FileReport struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Header HeaderType  `json:"header"`
+	Check  CheckStatus `json:"check"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// WorldReport describes an inspected world.
+type WorldReport struct {
+	// Path is the world directory, relative to the inspected path.
+	Path        string       `json:"path"`
+	Verdict     Verdict      `json:"verdict"`
+	Key         string       `json:"key,omitempty"`
+	KeyStrategy KeyStrategy  `json:"key_strategy,omitempty"`
+	KeyError    string       `json:"key_error,omitempty"`
+	Files       []FileReport `json:"files"`
+}
+
+// InspectReport is the result of Inspect.
+type InspectReport struct {
+	Path   string        `json:"path"`
+	Worlds []WorldReport `json:"worlds"`
+}
+
+// Inspect analyses every world found in path, which may be a world
+// directory, a directory containing worlds, or a .zip/.mcworld archive.
+func Inspect(inputPath string) (*InspectReport, error) {
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", inputPath, err)
+	}
+
+	if info.IsDir() {
+		return InspectFS(inputPath, os.DirFS(inputPath))
+	}
+
+	zipReader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", inputPath, err)
+	}
+	defer zipReader.Close()
+
+	return InspectFS(inputPath, zipReader)
+}
+
+// InspectFS is like Inspect for worlds stored in fsys. name is only used in
+// the report.
+func InspectFS(name string, fsys fs.FS) (*InspectReport, error) {
+	worlds, err := archive.FindWorldsFS(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find worlds: %w", err)
+	}
+
+	if len(worlds) == 0 {
+		return nil, fmt.Errorf("no world directories found in %s", name)
+	}
+
+	report := &InspectReport{Path: name}
+	for _, world := range worlds {
+		dbFS, err := fs.Sub(fsys, path.Join(world, "db"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open db directory of %s: %w", world, err)
+		}
+
+		worldReport, err := inspectWorld(dbFS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", world, err)
+		}
+		worldReport.Path = world
+		report.Worlds = append(report.Worlds, *worldReport)
+	}
+
+	return report, nil
+}
+
+func inspectWorld(dbFS fs.FS) (*WorldReport, error) {
+	entries, err := fs.ReadDir(dbFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read db directory: %w", err)
+	}
+
+	report := &WorldReport{}
+	encrypted := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", entry.Name(), err)
+		}
+
+		header, err := readFSHeader(dbFS, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+
+		headerType := identifyHeader(header)
+		if headerType.Encrypted() {
+			encrypted++
+		}
+
+		report.Files = append(report.Files, FileReport{
+			Name:   entry.Name(),
+			Size:   info.Size(),
+			Header: headerType,
+		})
+	}
+
+	var key []byte
+	if encrypted > 0 {
+		derived, err := deriveKeyFS(dbFS)
+		if err != nil {
+			report.KeyError = err.Error()
+		} else {
+			key = derived.Key
+			report.Key = fmt.Sprintf("%x", derived.Key)
+			report.KeyStrategy = derived.Strategy
+		}
+	}
+
+	dataFiles, failed := 0, 0
+	for i := range report.Files {
+		file := &report.Files[i]
+
+		var check FileCheck
+		switch {
+		case file.Header.Encrypted():
+			if key == nil {
+				check = FileCheck{Status: CheckUnverified, Detail: "no key"}
+			} else {
+				check = verifyFileKey(dbFS, file.Name, key)
+			}
+		default:
+			f, size, err := openSized(dbFS, file.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+			}
+			check = checkPlainReader(file.Name, f, size)
+			f.Close()
+		}
+
+		file.Check, file.Detail = check.Status, check.Detail
+		if check.Status == CheckFailed {
+			failed++
+		}
+		if isEncryptableFile(file.Name) {
+			dataFiles++
+		}
+	}
+
+	switch {
+	case failed > 0:
+		report.Verdict = VerdictCorrupted
+	case encrypted == 0:
+		report.Verdict = VerdictVanilla
+	case encrypted < dataFiles:
+		report.Verdict = VerdictMixed
+	default:
+		report.Verdict = VerdictEncrypted
+	}
+
+	return report, nil
+}
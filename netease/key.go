@@ -2,67 +2,479 @@ package netease
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// KeySize is the key length used by the current NetEase format.
+const KeySize = 8
+
+// KeyStrategy names the method a key was recovered with.
+type KeyStrategy string
+
+const (
+	// KeyStrategyCurrentManifest XORs CURRENT with the name of a MANIFEST
+	// file found in the db directory.
+	KeyStrategyCurrentManifest KeyStrategy = "current-manifest"
+	// KeyStrategyCurrentPrefix uses the "MANIFEST-" prefix every CURRENT
+	// starts with, for worlds whose MANIFEST files are missing.
+	KeyStrategyCurrentPrefix KeyStrategy = "current-prefix"
+	// KeyStrategyManifestComparator uses the "leveldb.BytewiseComparator"
+	// record at the start of every MANIFEST.
+	KeyStrategyManifestComparator KeyStrategy = "manifest-comparator"
+	// KeyStrategyTableFooter uses the magic number that ends every .ldb table.
+	KeyStrategyTableFooter KeyStrategy = "table-footer"
+	// KeyStrategyLogRecord solves the header and checksum of the first record
+	// of a .log file.
+	KeyStrategyLogRecord KeyStrategy = "log-record"
+)
+
+// DerivedKey is a key recovered from an encrypted world.
+type DerivedKey struct {
+	Key      []byte
+	Strategy KeyStrategy
+	// Source is the db file the key was recovered from.
+	Source string
+}
+
+type keyStrategy struct {
+	name   KeyStrategy
+	derive func(fsys fs.FS, names []string) (*DerivedKey, error)
+}
+
+// keyStrategies are tried in order, from the cheapest and most reliable to
+// the ones that only need a single intact file.
+var keyStrategies = []keyStrategy{
+	{KeyStrategyCurrentManifest, deriveFromCurrentManifest},
+	{KeyStrategyCurrentPrefix, deriveFromCurrentPrefix},
+	{KeyStrategyManifestComparator, deriveFromManifestComparator},
+	{KeyStrategyTableFooter, deriveFromTableFooter},
+	{KeyStrategyLogRecord, deriveFromLogRecord},
+}
+
 func DeriveKey(dbDir string) ([]byte, error) {
-	manifestName, err := findManifestFile(dbDir)
+	derived, err := DeriveKeyWithStrategy(dbDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find MANIFEST file: %w", err)
+		return nil, err
+	}
+	return derived.Key, nil
+}
+
+// DeriveKeyWithStrategy recovers the key of a db directory with the codec
+// its CURRENT file is encrypted with.
+func DeriveKeyWithStrategy(dbDir string) (*DerivedKey, error) {
+	return deriveKeyFS(os.DirFS(dbDir))
+}
+
+func deriveKeyFS(fsys fs.FS) (*DerivedKey, error) {
+	return worldCodec(fsys).DeriveKey(fsys)
+}
+
+// worldCodec returns the codec of the CURRENT file of a db directory, or
+// DefaultCodec if CURRENT is missing or not encrypted, so that keys can
+// still be recovered from the other files.
+func worldCodec(fsys fs.FS) Codec {
+	if header, err := readFSHeader(fsys, "CURRENT"); err == nil {
+		if c := DetectCodec(header); c != nil {
+			return c
+		}
 	}
+	return DefaultCodec
+}
 
-	currentPath := filepath.Join(dbDir, "CURRENT")
-	currentData, err := os.ReadFile(currentPath)
+// deriveXORKey recovers the key of the current format, trying every known
+// strategy until one succeeds.
+func deriveXORKey(fsys fs.FS) (*DerivedKey, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CURRENT file: %w", err)
+		return nil, fmt.Errorf("failed to read db directory: %w", err)
 	}
 
-	if err := ValidateDecryptableFile(currentData); err != nil {
-		return nil, fmt.Errorf("CURRENT file is not decryptable: %w", err)
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
 	}
 
-	currentBody := currentData[4:]
+	var errs []error
+	for _, strategy := range keyStrategies {
+		derived, err := strategy.derive(fsys, names)
+		if err == nil {
+			derived.Strategy = strategy.name
+			return derived, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", strategy.name, err))
+	}
 
-	// Add newline to manifestName
-	manifestWithNewline := append(manifestName, '\n')
+	return nil, fmt.Errorf("no key recovery strategy succeeded: %w", errors.Join(errs...))
+}
 
-	if len(manifestWithNewline) != 16 {
-		return nil, fmt.Errorf("manifest name with newline must be exactly 16 bytes, got %d", len(manifestWithNewline))
+func deriveFromCurrentManifest(fsys fs.FS, names []string) (*DerivedKey, error) {
+	body, err := readEncryptedBody(fsys, "CURRENT")
+	if err != nil {
+		return nil, err
 	}
 
-	if len(currentBody) < 16 {
-		return nil, fmt.Errorf("current body must be at least 16 bytes, got %d", len(currentBody))
+	manifests := filterNames(names, func(name string) bool {
+		return strings.HasPrefix(name, "MANIFEST-")
+	})
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("%w found in db directory", ErrNoManifest)
 	}
 
-	keyRaw := make([]byte, 16)
-	for i := 0; i < 16; i++ {
-		keyRaw[i] = currentBody[i] ^ manifestWithNewline[i]
+	// Newer manifests are more likely to be the one CURRENT points to.
+	sort.Sort(sort.Reverse(sort.StringSlice(manifests)))
+
+	for _, manifestName := range manifests {
+		plain := []byte(manifestName + "\n")
+		if len(body) != len(plain) {
+			continue
+		}
+
+		key := make([]byte, KeySize)
+		for i := range KeySize {
+			key[i] = body[i] ^ plain[i]
+		}
+
+		if bytes.Equal(xorDecrypt(body, key), plain) {
+			return &DerivedKey{Key: key, Source: "CURRENT"}, nil
+		}
 	}
 
-	first8 := keyRaw[:8]
-	last8 := keyRaw[8:]
+	return nil, fmt.Errorf("CURRENT does not match any of %d MANIFEST files", len(manifests))
+}
+
+func deriveFromCurrentPrefix(fsys fs.FS, _ []string) (*DerivedKey, error) {
+	body, err := readEncryptedBody(fsys, "CURRENT")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := []byte("MANIFEST-")
+	if len(body) <= len(prefix) {
+		return nil, fmt.Errorf("CURRENT is too short, got %d bytes", len(body))
+	}
+
+	key := make([]byte, KeySize)
+	for i := range KeySize {
+		key[i] = body[i] ^ prefix[i]
+	}
+
+	if err := checkCurrent(xorDecrypt(body, key)); err != nil {
+		return nil, err
+	}
+
+	return &DerivedKey{Key: key, Source: "CURRENT"}, nil
+}
+
+func deriveFromManifestComparator(fsys fs.FS, names []string) (*DerivedKey, error) {
+	// VersionEdit tag 1 (comparator) followed by the length-prefixed name,
+	// right after the header of the first log record.
+	comparator := append([]byte{0x01, 26}, "leveldb.BytewiseComparator"...)
+
+	manifests := filterNames(names, func(name string) bool {
+		return strings.HasPrefix(name, "MANIFEST-")
+	})
+
+	var errs []error
+	for _, name := range manifests {
+		body, err := readEncryptedBody(fsys, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if len(body) < logHeaderSize+len(comparator) {
+			errs = append(errs, fmt.Errorf("%s is too short", name))
+			continue
+		}
+
+		key := make([]byte, KeySize)
+		for i := range KeySize {
+			offset := logHeaderSize + i
+			key[offset%KeySize] = body[offset] ^ comparator[i]
+		}
+
+		plain := xorDecrypt(body, key)
+		if !bytes.Equal(plain[logHeaderSize:logHeaderSize+len(comparator)], comparator) {
+			errs = append(errs, fmt.Errorf("%s does not start with a comparator record", name))
+			continue
+		}
+
+		if records, issue := checkLogRecords(plain); records == 0 && issue != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, issue))
+			continue
+		}
+
+		return &DerivedKey{Key: key, Source: name}, nil
+	}
+
+	return nil, joinOrMissing(errs, fmt.Errorf("%w found in db directory", ErrNoManifest))
+}
+
+func deriveFromTableFooter(fsys fs.FS, names []string) (*DerivedKey, error) {
+	magic := binary.LittleEndian.AppendUint64(nil, tableMagic)
+
+	var errs []error
+	for _, name := range filterNames(names, isTableFile) {
+		tail, size, err := readEncryptedTail(fsys, name, tableFooterSize)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if size < tableFooterSize {
+			errs = append(errs, fmt.Errorf("%s is smaller than a table footer", name))
+			continue
+		}
+
+		// tail is the footer, which starts at body offset base.
+		base := size - tableFooterSize
+		key := make([]byte, KeySize)
+		for i := range KeySize {
+			offset := base + tableFooterSize - KeySize + i
+			key[offset%KeySize] = tail[tableFooterSize-KeySize+i] ^ magic[i]
+		}
+
+		footer := make([]byte, tableFooterSize)
+		for i := range footer {
+			footer[i] = tail[i] ^ key[(base+i)%KeySize]
+		}
+
+		if err := checkTableFooter(footer, size); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
 
-	if !bytes.Equal(first8, last8) {
-		return nil, fmt.Errorf("key verification failed: first 8 bytes do not match last 8 bytes")
+		return &DerivedKey{Key: key, Source: name}, nil
 	}
 
-	return first8, nil
+	return nil, joinOrMissing(errs, errors.New("no .ldb file found in db directory"))
 }
 
-func findManifestFile(dbDir string) ([]byte, error) {
-	entries, err := os.ReadDir(dbDir)
+func deriveFromLogRecord(fsys fs.FS, names []string) (*DerivedKey, error) {
+	var errs []error
+	for _, name := range filterNames(names, func(name string) bool {
+		return strings.HasSuffix(name, ".log")
+	}) {
+		body, err := readEncryptedBody(fsys, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		key, err := solveLogRecordKey(body)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		return &DerivedKey{Key: key, Source: name}, nil
+	}
+
+	return nil, joinOrMissing(errs, errors.New("no .log file found in db directory"))
+}
+
+// solveLogRecordKey recovers the key from the first record of an encrypted
+// .log file. Every record holds a WriteBatch whose 8-byte sequence number
+// and 4-byte count have zero high bytes in practice, which reveals five key
+// bytes including the ones covering the record length. The remaining three
+// bytes are searched exhaustively against the record checksum; since CRC32 is
+// affine over XOR, each guess costs a few table lookups.
+func solveLogRecordKey(block []byte) ([]byte, error) {
+	const (
+		seqOffset   = logHeaderSize
+		countOffset = seqOffset + 8
+		dataOffset  = countOffset + 4
+	)
+
+	if len(block) < dataOffset+1 {
+		return nil, fmt.Errorf("first record is too short")
+	}
+
+	key := make([]byte, KeySize)
+	// Sequence bytes 5..7 and count bytes 2..3 are zero.
+	for _, offset := range []int{seqOffset + 5, seqOffset + 6, seqOffset + 7, countOffset + 2, countOffset + 3} {
+		key[offset%KeySize] = block[offset]
+	}
+
+	recordType := block[6] ^ key[6]
+	if recordType != logRecordFull && recordType != logRecordFirst {
+		return nil, fmt.Errorf("first record has invalid type %d", recordType)
+	}
+
+	length := int(binary.LittleEndian.Uint16([]byte{block[4] ^ key[4], block[5] ^ key[5]}))
+	end := logHeaderSize + length
+	if length < dataOffset-logHeaderSize || end > len(block) {
+		return nil, fmt.Errorf("first record has invalid length %d", length)
+	}
+
+	// checked covers the record type and data, which start at body offset 6.
+	checked := block[6:end]
+	base := crc32.Update(0, castagnoli, checked)
+	zero := crc32.Update(0, castagnoli, make([]byte, len(checked)))
+
+	// contribution[j][v] is the change of the checksum when every byte that
+	// uses key byte j is XORed with v.
+	var contribution [KeySize][256]uint32
+	mask := make([]byte, len(checked))
+	for j := range KeySize {
+		for bit := range 8 {
+			for i := range mask {
+				mask[i] = 0
+				if (6+i)%KeySize == j {
+					mask[i] = 1 << bit
+				}
+			}
+			delta := crc32.Update(0, castagnoli, mask) ^ zero
+			for v := range 256 {
+				if v&(1<<bit) != 0 {
+					contribution[j][v] ^= delta
+				}
+			}
+		}
+	}
+
+	known := base
+	for _, j := range []int{1, 2, 4, 5, 6} {
+		known ^= contribution[j][key[j]]
+	}
+
+	var candidates [][]byte
+	for k0 := range 256 {
+		for k3 := range 256 {
+			for k7 := range 256 {
+				crc := known ^ contribution[0][k0] ^ contribution[3][k3] ^ contribution[7][k7]
+				if unmaskCRC(storedCRC(block, byte(k0), key[1], key[2], byte(k3))) != crc {
+					continue
+				}
+
+				candidate := bytes.Clone(key)
+				candidate[0], candidate[3], candidate[7] = byte(k0), byte(k3), byte(k7)
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	// A 32-bit checksum leaves a few false positives over 2^24 guesses; keep
+	// the candidate that also validates the records that follow.
+	best, bestRecords := []byte(nil), 0
+	for _, candidate := range candidates {
+		records, _ := checkLogRecords(xorDecrypt(block, candidate))
+		if records > bestRecords {
+			best, bestRecords = candidate, records
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no key matches the first record checksum")
+	}
+
+	return best, nil
+}
+
+func storedCRC(block []byte, k0, k1, k2, k3 byte) uint32 {
+	return uint32(block[0]^k0) | uint32(block[1]^k1)<<8 | uint32(block[2]^k2)<<16 | uint32(block[3]^k3)<<24
+}
+
+// readEncryptedBody returns the start of the body of an encrypted file, up to
+// the first log block. That is all of CURRENT and enough of a .log or
+// MANIFEST file for its first records, without reading large files whole.
+func readEncryptedBody(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read db directory: %w", err)
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
 	}
+	defer file.Close()
 
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "MANIFEST-") {
-			return []byte(entry.Name()), nil
+	data, err := io.ReadAll(io.LimitReader(file, HeaderSize+logBlockSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if identifyHeader(data) != HeaderTypeNetEaseCurrent {
+		return nil, fmt.Errorf("%s is not encrypted with the current NetEase format", name)
+	}
+
+	return data[HeaderSize:], nil
+}
+
+// readEncryptedTail returns the last n bytes of the body of an encrypted file
+// along with the body size.
+func readEncryptedTail(fsys fs.FS, name string, n int) ([]byte, int, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if identifyHeader(header) != HeaderTypeNetEaseCurrent {
+		return nil, 0, fmt.Errorf("%s is not encrypted with the current NetEase format", name)
+	}
+
+	size := int(info.Size()) - HeaderSize
+	skip := max(size-n, 0)
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(int64(HeaderSize+skip), io.SeekStart); err != nil {
+			return nil, 0, fmt.Errorf("failed to seek %s: %w", name, err)
 		}
+	} else if _, err := io.CopyN(io.Discard, file, int64(skip)); err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	tail, err := io.ReadAll(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return tail, size, nil
+}
+
+func readFSHeader(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	return nil, fmt.Errorf("no MANIFEST file found in db directory")
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+func filterNames(names []string, keep func(string) bool) []string {
+	var result []string
+	for _, name := range names {
+		if keep(name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func joinOrMissing(errs []error, missing error) error {
+	if len(errs) == 0 {
+		return missing
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,210 @@
+package netease
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+var testKey = []byte{0x3a, 0x91, 0x5c, 0x07, 0xe2, 0x48, 0xbd, 0x16}
+
+// encryptXOR returns plain as a file of the current NetEase format.
+func encryptXOR(plain, key []byte) []byte {
+	body := make([]byte, len(plain))
+	NewXORStream(key).XORKeyStream(body, plain)
+	return append(bytes.Clone(headerNetEaseCurrent), body...)
+}
+
+// encryptedFS returns a db directory with files encrypted with key.
+func encryptedFS(key []byte, files map[string][]byte) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, plain := range files {
+		fsys[name] = &fstest.MapFile{Data: encryptXOR(plain, key), Mode: 0644}
+	}
+	return fsys
+}
+
+// manifestLog is a MANIFEST starting with the comparator record LevelDB
+// writes first.
+func manifestLog() []byte {
+	edit := append([]byte{0x01, 26}, "leveldb.BytewiseComparator"...)
+	edit = append(edit, 0x02, 0x03)           // log number
+	return buildLog(edit, []byte{0x03, 0x05}) // next file number
+}
+
+// writeBatchLog is a .log holding write batches with small sequence numbers
+// and counts, like every real one.
+func writeBatchLog() []byte {
+	var records [][]byte
+	for seq := uint64(1); seq <= 3; seq++ {
+		batch := binary.LittleEndian.AppendUint64(nil, seq)
+		batch = binary.LittleEndian.AppendUint32(batch, 1)
+		batch = append(batch, 0x01, 5)
+		batch = append(batch, "chunk"...)
+		batch = append(batch, 3)
+		batch = append(batch, byte(seq), 0xff, 0x10)
+		records = append(records, batch)
+	}
+	return buildLog(records...)
+}
+
+func TestDeriveXORKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string][]byte
+		strategy KeyStrategy
+		source   string
+	}{
+		{
+			name: "CURRENT and MANIFEST",
+			files: map[string][]byte{
+				"CURRENT":         []byte("MANIFEST-000002\n"),
+				"MANIFEST-000002": manifestLog(),
+			},
+			strategy: KeyStrategyCurrentManifest,
+			source:   "CURRENT",
+		},
+		{
+			name:     "CURRENT only",
+			files:    map[string][]byte{"CURRENT": []byte("MANIFEST-000002\n")},
+			strategy: KeyStrategyCurrentPrefix,
+			source:   "CURRENT",
+		},
+		{
+			name:     "MANIFEST only",
+			files:    map[string][]byte{"MANIFEST-000002": manifestLog()},
+			strategy: KeyStrategyManifestComparator,
+			source:   "MANIFEST-000002",
+		},
+		{
+			name:     "table only",
+			files:    map[string][]byte{"000005.ldb": buildTable()},
+			strategy: KeyStrategyTableFooter,
+			source:   "000005.ldb",
+		},
+		{
+			name:     "log only",
+			files:    map[string][]byte{"000003.log": writeBatchLog()},
+			strategy: KeyStrategyLogRecord,
+			source:   "000003.log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derived, err := deriveXORKey(encryptedFS(testKey, tt.files))
+			if err != nil {
+				t.Fatalf("deriveXORKey: %v", err)
+			}
+			if !bytes.Equal(derived.Key, testKey) {
+				t.Errorf("key = %x, want %x", derived.Key, testKey)
+			}
+			if derived.Strategy != tt.strategy {
+				t.Errorf("strategy = %s, want %s", derived.Strategy, tt.strategy)
+			}
+			if derived.Source != tt.source {
+				t.Errorf("source = %s, want %s", derived.Source, tt.source)
+			}
+		})
+	}
+}
+
+func TestKeyStrategiesReject(t *testing.T) {
+	garbage := bytes.Repeat([]byte{0x5a, 0xc3, 0x17, 0x88, 0x02, 0xee, 0x71}, 40)
+
+	tests := []struct {
+		name   string
+		derive func(fsys fs.FS, names []string) (*DerivedKey, error)
+		file   string
+		plain  []byte
+	}{
+		{"current-manifest short", deriveFromCurrentManifest, "CURRENT", []byte("MANI")},
+		{"current-prefix short", deriveFromCurrentPrefix, "CURRENT", []byte("MANIFEST")},
+		{"current-prefix garbage", deriveFromCurrentPrefix, "CURRENT", garbage},
+		{"manifest-comparator short", deriveFromManifestComparator, "MANIFEST-000002", manifestLog()[:10]},
+		{"manifest-comparator garbage", deriveFromManifestComparator, "MANIFEST-000002", garbage},
+		{"table-footer short", deriveFromTableFooter, "000005.ldb", buildTable()[:20]},
+		{"table-footer garbage", deriveFromTableFooter, "000005.ldb", garbage},
+		{"log-record short", deriveFromLogRecord, "000003.log", writeBatchLog()[:12]},
+		{"log-record garbage", deriveFromLogRecord, "000003.log", garbage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := encryptedFS(testKey, map[string][]byte{tt.file: tt.plain})
+			names := []string{tt.file}
+			if tt.file == "CURRENT" {
+				// A MANIFEST for CURRENT to be matched against.
+				names = append(names, "MANIFEST-000002")
+			}
+			derived, err := tt.derive(fsys, names)
+			if err == nil {
+				t.Fatalf("derived key %x, want an error", derived.Key)
+			}
+		})
+	}
+}
+
+func TestDeriveXORKeyNoManifest(t *testing.T) {
+	garbage := bytes.Repeat([]byte{0x5a, 0xc3, 0x17, 0x88, 0x02, 0xee, 0x71}, 40)
+	fsys := encryptedFS(testKey, map[string][]byte{"000003.log": garbage})
+
+	_, err := deriveXORKey(fsys)
+	if !errors.Is(err, ErrNoManifest) {
+		t.Errorf("error = %v, want %v", err, ErrNoManifest)
+	}
+}
+
+// countingFS counts the bytes read from its files.
+type countingFS struct {
+	fs.FS
+	read int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	file, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingFile{File: file, fs: c}, nil
+}
+
+type countingFile struct {
+	fs.File
+	fs *countingFS
+}
+
+func (f *countingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.fs.read += n
+	return n, err
+}
+
+func TestReadEncryptedBodyFirstBlock(t *testing.T) {
+	// A log of 4 MiB, of which only the first block is needed.
+	data := writeBatchLog()
+	data = append(data, make([]byte, 4<<20)...)
+	fsys := &countingFS{FS: encryptedFS(testKey, map[string][]byte{"000003.log": data})}
+
+	body, err := readEncryptedBody(fsys, "000003.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != logBlockSize {
+		t.Errorf("body is %d bytes, want %d", len(body), logBlockSize)
+	}
+	if fsys.read > HeaderSize+logBlockSize {
+		t.Errorf("read %d bytes, want at most %d", fsys.read, HeaderSize+logBlockSize)
+	}
+
+	derived, err := deriveFromLogRecord(fsys, []string{"000003.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(derived.Key, testKey) {
+		t.Errorf("key = %x, want %x", derived.Key, testKey)
+	}
+}
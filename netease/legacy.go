@@ -0,0 +1,14 @@
+package netease
+
+import "fmt"
+
+// LegacyKeySize is the key length used by the legacy (90 1D 30 01) format.
+const LegacyKeySize = 16
+
+// errLegacyCipher is returned for every attempt to decrypt or encrypt legacy
+// files. The format is believed to use AES-CFB8, but how its key and IV are
+// formed is not documented and no real legacy world was available to check
+// a guess against. Legacy files are detected and reported, but neither
+// decrypted nor written, until such a world or a source for the scheme
+// allows a known-answer test.
+var errLegacyCipher = fmt.Errorf("%w is not supported, its cipher is not known", ErrLegacyEncryption)
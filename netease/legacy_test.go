@@ -0,0 +1,81 @@
+package netease
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var legacyTestKey = []byte("0123456789abcdef")
+
+// writeLegacyWorld writes a world whose db files carry the legacy header,
+// and returns its directory. The bodies are arbitrary, as no legacy world
+// can be produced.
+func writeLegacyWorld(t *testing.T) string {
+	t.Helper()
+
+	worldDir := filepath.Join(t.TempDir(), "world")
+	dbDir := filepath.Join(worldDir, "db")
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"CURRENT", "MANIFEST-000002", "000003.log"} {
+		data := append(bytes.Clone(headerNetEaseLegacy), bytes.Repeat([]byte{0x5a, 0xc3}, 64)...)
+		if err := os.WriteFile(filepath.Join(dbDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return worldDir
+}
+
+func TestLegacyDetected(t *testing.T) {
+	worldDir := writeLegacyWorld(t)
+
+	counts, err := CountHeaderTypes(filepath.Join(worldDir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[HeaderTypeNetEaseLegacy] != 3 {
+		t.Errorf("header counts = %v, want 3 legacy files", counts)
+	}
+	if !HeaderTypeNetEaseLegacy.Encrypted() {
+		t.Error("legacy files are not reported as encrypted")
+	}
+}
+
+func TestLegacyUnsupported(t *testing.T) {
+	worldDir := writeLegacyWorld(t)
+
+	if _, err := DecryptFile(filepath.Join(worldDir, "db", "CURRENT"), legacyTestKey); !errors.Is(err, ErrLegacyEncryption) {
+		t.Errorf("DecryptFile error = %v, want %v", err, ErrLegacyEncryption)
+	}
+
+	if _, err := NewEncryptWriterWithCodec(&bytes.Buffer{}, legacyCodec{}, legacyTestKey); !errors.Is(err, ErrLegacyEncryption) {
+		t.Errorf("NewEncryptWriterWithCodec error = %v, want %v", err, ErrLegacyEncryption)
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  []byte
+	}{
+		{name: "with key", key: legacyTestKey},
+		{name: "without key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := filepath.Join(t.TempDir(), "decrypted")
+			_, err := DecryptWorldDBWithOptions(worldDir, WorldOptions{Key: tt.key, OutputDir: outputDir})
+			if !errors.Is(err, ErrLegacyEncryption) {
+				t.Errorf("error = %v, want %v", err, ErrLegacyEncryption)
+			}
+			if errors.Is(err, ErrKeyMismatch) {
+				t.Errorf("error = %v, reported as a key mismatch", err)
+			}
+			if _, err := os.Stat(outputDir); err == nil {
+				t.Error("output directory was written despite the error")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package netease
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// LevelDB on-disk format constants, see leveldb/doc/log_format.md and
+// leveldb/doc/table_format.md.
+const (
+	logBlockSize    = 32768
+	logHeaderSize   = 7
+	tableFooterSize = 48
+	tableMagic      = 0xdb4775248b80fb57
+	crcMaskDelta    = 0xa282ead8
+)
+
+const (
+	logRecordZero = iota
+	logRecordFull
+	logRecordFirst
+	logRecordMiddle
+	logRecordLast
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func unmaskCRC(masked uint32) uint32 {
+	rot := masked - crcMaskDelta
+	return rot>>17 | rot<<15
+}
+
+// checkCurrent verifies the content of a decrypted CURRENT file.
+func checkCurrent(data []byte) error {
+	name, ok := bytes.CutSuffix(data, []byte("\n"))
+	if !ok {
+		return fmt.Errorf("CURRENT does not end with a newline")
+	}
+
+	number, ok := bytes.CutPrefix(name, []byte("MANIFEST-"))
+	if !ok || len(number) == 0 {
+		return fmt.Errorf("CURRENT does not name a MANIFEST file")
+	}
+
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("CURRENT does not name a MANIFEST file")
+		}
+	}
+
+	return nil
+}
+
+// logIssue describes a problem found in a log-format file (MANIFEST or .log).
+// A torn record at the very end of a file is what LevelDB leaves behind after
+// a crash and is reported as truncated rather than corrupt.
+type logIssue struct {
+	Offset    int
+	Truncated bool
+	Reason    string
+}
+
+func (i *logIssue) Error() string {
+	return fmt.Sprintf("offset %d: %s", i.Offset, i.Reason)
+}
+
+// checkLogRecords walks every physical record of a log-format file and
+// verifies its CRC. It returns the number of valid records and the first
+// problem found, if any.
+func checkLogRecords(data []byte) (int, *logIssue) {
+	records, issue, _ := checkLogReader(bytes.NewReader(data), int64(len(data)))
+	return records, issue
+}
+
+// checkLogReader is checkLogRecords for a file of the given size read from
+// r. Records never cross blocks, so the file is checked one block at a time
+// and memory use does not depend on its size. The error is set if r fails.
+func checkLogReader(r io.Reader, size int64) (int, *logIssue, error) {
+	block := make([]byte, logBlockSize)
+	records := 0
+	zeroFrom := -1
+
+	for base := int64(0); base < size; base += logBlockSize {
+		n, err := io.ReadFull(r, block[:min(logBlockSize, size-base)])
+		if err != nil {
+			return records, nil, err
+		}
+		data := block[:n]
+
+		if zeroFrom >= 0 {
+			// Preallocated space must stay zero up to the end of the file.
+			if !isZero(data) {
+				return records, &logIssue{Offset: zeroFrom, Reason: "unexpected zero-length record"}, nil
+			}
+			continue
+		}
+
+		blockRecords, issue, zeroAt := checkLogBlock(data, int(base), base+int64(n) == size)
+		records += blockRecords
+		if issue != nil {
+			return records, issue, nil
+		}
+		zeroFrom = zeroAt
+	}
+
+	return records, nil, nil
+}
+
+// checkLogBlock checks the records of the block that starts at offset base
+// of a file. last is set for the final block, which may be partial. If the
+// block ends in zero-filled preallocated space, zeroFrom is its offset in the
+// file, otherwise -1.
+func checkLogBlock(data []byte, base int, last bool) (records int, issue *logIssue, zeroFrom int) {
+	offset := 0
+
+	for offset < len(data) {
+		blockLeft := logBlockSize - offset
+		if blockLeft < logHeaderSize {
+			// Trailer of a block, always zero filled.
+			break
+		}
+
+		if len(data)-offset < logHeaderSize {
+			return records, &logIssue{Offset: base + offset, Truncated: true, Reason: "truncated record header"}, -1
+		}
+
+		header := data[offset : offset+logHeaderSize]
+		length := int(binary.LittleEndian.Uint16(header[4:6]))
+		recordType := header[6]
+
+		if recordType == logRecordZero && length == 0 {
+			// Preallocated space at the end of a file.
+			if isZero(data[offset:]) {
+				return records, nil, base + offset
+			}
+			return records, &logIssue{Offset: base + offset, Reason: "unexpected zero-length record"}, -1
+		}
+
+		if recordType > logRecordLast {
+			return records, &logIssue{Offset: base + offset, Reason: fmt.Sprintf("invalid record type %d", recordType)}, -1
+		}
+
+		if logHeaderSize+length > blockLeft {
+			return records, &logIssue{Offset: base + offset, Reason: "record crosses block boundary"}, -1
+		}
+
+		end := offset + logHeaderSize + length
+		if end > len(data) {
+			return records, &logIssue{Offset: base + offset, Truncated: true, Reason: "truncated record"}, -1
+		}
+
+		expected := unmaskCRC(binary.LittleEndian.Uint32(header[0:4]))
+		actual := crc32.Update(0, castagnoli, data[offset+6:end])
+		if expected != actual {
+			return records, &logIssue{Offset: base + offset, Truncated: last && end == len(data), Reason: "record checksum mismatch"}, -1
+		}
+
+		records++
+		offset = end
+	}
+
+	return records, nil, -1
+}
+
+// checkTableFooter verifies the decrypted footer of a .ldb table of the given
+// size: the magic number and that both block handles point inside the file.
+func checkTableFooter(footer []byte, size int) error {
+	if size < tableFooterSize || len(footer) != tableFooterSize {
+		return fmt.Errorf("table is smaller than its footer")
+	}
+
+	if binary.LittleEndian.Uint64(footer[40:]) != tableMagic {
+		return fmt.Errorf("table magic number mismatch")
+	}
+
+	handles := footer[:40]
+	limit := uint64(size - tableFooterSize)
+	for _, name := range []string{"metaindex", "index"} {
+		offset, n := binary.Uvarint(handles)
+		if n <= 0 {
+			return fmt.Errorf("invalid %s block handle", name)
+		}
+		handles = handles[n:]
+
+		size, n := binary.Uvarint(handles)
+		if n <= 0 {
+			return fmt.Errorf("invalid %s block handle", name)
+		}
+		handles = handles[n:]
+
+		// Every block is followed by a 1-byte type and a 4-byte CRC.
+		if offset > limit || size > limit-offset || offset+size+5 > limit {
+			return fmt.Errorf("%s block handle points outside the table", name)
+		}
+	}
+
+	if !isZero(handles) {
+		return fmt.Errorf("table footer padding is not zero")
+	}
+
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isTableFile(name string) bool {
+	return strings.HasSuffix(name, ".ldb") || strings.HasSuffix(name, ".sst")
+}
@@ -0,0 +1,165 @@
+package netease
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// buildLog encodes records in the LevelDB log format, fragmenting them
+// across blocks and zero-filling block trailers like LevelDB does.
+func buildLog(records ...[]byte) []byte {
+	var out []byte
+	for _, record := range records {
+		first := true
+		for {
+			blockLeft := logBlockSize - len(out)%logBlockSize
+			if blockLeft < logHeaderSize {
+				out = append(out, make([]byte, blockLeft)...)
+				blockLeft = logBlockSize
+			}
+
+			n := min(len(record), blockLeft-logHeaderSize)
+			last := n == len(record)
+			recordType := byte(logRecordMiddle)
+			switch {
+			case first && last:
+				recordType = logRecordFull
+			case first:
+				recordType = logRecordFirst
+			case last:
+				recordType = logRecordLast
+			}
+
+			crc := crc32.Update(0, castagnoli, []byte{recordType})
+			crc = crc32.Update(crc, castagnoli, record[:n])
+			rot := crc>>15 | crc<<17
+			header := binary.LittleEndian.AppendUint32(nil, rot+crcMaskDelta)
+			header = binary.LittleEndian.AppendUint16(header, uint16(n))
+			header = append(header, recordType)
+
+			out = append(out, header...)
+			out = append(out, record[:n]...)
+			record = record[n:]
+			first = false
+			if last {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// buildTable returns a minimal table: a block area followed by a footer
+// whose handles point into it.
+func buildTable() []byte {
+	table := bytes.Repeat([]byte{0xab}, 100)
+	var handles []byte
+	handles = binary.AppendUvarint(handles, 0)  // metaindex offset
+	handles = binary.AppendUvarint(handles, 20) // metaindex size
+	handles = binary.AppendUvarint(handles, 25) // index offset
+	handles = binary.AppendUvarint(handles, 30) // index size
+	footer := make([]byte, tableFooterSize)
+	copy(footer, handles)
+	binary.LittleEndian.PutUint64(footer[40:], tableMagic)
+	return append(table, footer...)
+}
+
+func TestCheckLogReader(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 5000) // a FIRST and a LAST fragment
+	valid := buildLog([]byte("first"), big, []byte("last"))
+
+	torn := bytes.Clone(valid[:len(valid)-2])
+
+	corrupt := bytes.Clone(valid)
+	corrupt[logHeaderSize+1] ^= 0xff
+
+	preallocated := append(bytes.Clone(valid), make([]byte, 2*logBlockSize)...)
+
+	garbageAfterZeros := append(bytes.Clone(preallocated), 0x01)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		records   int
+		issue     bool
+		truncated bool
+	}{
+		{name: "valid", data: valid, records: 4},
+		{name: "torn last record", data: torn, records: 3, issue: true, truncated: true},
+		{name: "checksum mismatch", data: corrupt, records: 0, issue: true},
+		{name: "preallocated space", data: preallocated, records: 4},
+		{name: "data after preallocated space", data: garbageAfterZeros, records: 4, issue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, issue, err := checkLogReader(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records != tt.records {
+				t.Errorf("records = %d, want %d", records, tt.records)
+			}
+			if (issue != nil) != tt.issue {
+				t.Fatalf("issue = %v, want issue %v", issue, tt.issue)
+			}
+			if issue != nil && issue.Truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", issue.Truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+// onlyReader hides the Seek method of a reader.
+type onlyReader struct{ r *bytes.Reader }
+
+func (o onlyReader) Read(p []byte) (int, error) { return o.r.Read(p) }
+
+func TestCheckPlainReader(t *testing.T) {
+	log := buildLog([]byte("record"))
+	table := buildTable()
+	badTable := bytes.Clone(table)
+	badTable[len(badTable)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want CheckStatus
+	}{
+		{name: "CURRENT", data: []byte("MANIFEST-000002\n"), want: CheckPassed},
+		{name: "CURRENT", data: []byte("garbage"), want: CheckFailed},
+		{name: "CURRENT", data: bytes.Repeat([]byte("A"), maxCurrentSize+1), want: CheckFailed},
+		{name: "MANIFEST-000002", data: log, want: CheckPassed},
+		{name: "000003.log", data: log[:len(log)-1], want: CheckFailed},
+		{name: "000005.ldb", data: table, want: CheckPassed},
+		{name: "000005.ldb", data: badTable, want: CheckFailed},
+		{name: "000005.ldb", data: table[:10], want: CheckFailed},
+		{name: "LOCK", data: nil, want: CheckUnverified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := int64(len(tt.data))
+			seeking := checkPlainReader(tt.name, bytes.NewReader(tt.data), size)
+			streaming := checkPlainReader(tt.name, onlyReader{bytes.NewReader(tt.data)}, size)
+			if seeking.Status != tt.want || streaming.Status != tt.want {
+				t.Errorf("status = %s (seeking), %s (streaming), want %s: %s", seeking.Status, streaming.Status, tt.want, seeking.Detail)
+			}
+		})
+	}
+}
+
+func TestCheckPlainReaderDecrypting(t *testing.T) {
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	table := buildTable()
+	encrypted := make([]byte, len(table))
+	NewXORStream(key).XORKeyStream(encrypted, table)
+
+	r := cipher.StreamReader{S: NewXORStream(key), R: bytes.NewReader(encrypted)}
+	if check := checkPlainReader("000005.ldb", r, int64(len(table))); check.Status != CheckPassed {
+		t.Errorf("status = %s: %s", check.Status, check.Detail)
+	}
+}
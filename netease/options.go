@@ -0,0 +1,146 @@
+package netease
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yechentide/necrack/archive"
+)
+
+// WorldOptions controls where and how a world is processed.
+type WorldOptions struct {
+	// Key overrides key derivation and is checked against CURRENT before
+	// decryption. Worlds whose codec cannot derive keys, like legacy worlds,
+	// always require it.
+	Key []byte
+
+	// Codec encrypts the files of a world. Defaults to DefaultCodec.
+	// Decryption detects the codec of every file from its header.
+	Codec Codec
+
+	// OutputDir receives the processed copy of the world. When empty, a
+	// timestamped directory is created next to the source world. The copy is
+	// built in a hidden staging directory and only moved there on success.
+	OutputDir string
+
+	// InPlace replaces the source world with the processed one and keeps the
+	// original as a timestamped backup next to it.
+	InPlace bool
+
+	// Force allows OutputDir to replace an existing directory.
+	Force bool
+
+	// Verify checks every decrypted file against the LevelDB format once it
+	// has been written. Files that fail the check abort the operation.
+	Verify bool
+
+	// Jobs is the number of files processed concurrently. Zero or less uses
+	// one worker per CPU.
+	Jobs int
+
+	// Context cancels the operation between files. Defaults to
+	// context.Background().
+	Context context.Context
+
+	// Progress is called for every file. It may be called from several
+	// goroutines at once.
+	Progress func(ProgressEvent)
+
+	// Logger receives diagnostic messages. Nothing is logged when nil.
+	Logger Logger
+}
+
+func (o WorldOptions) codec() Codec {
+	if o.Codec == nil {
+		return DefaultCodec
+	}
+	return o.Codec
+}
+
+func (o WorldOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o WorldOptions) logger() Logger {
+	if o.Logger == nil {
+		return nopLogger{}
+	}
+	return o.Logger
+}
+
+func (o WorldOptions) report(event ProgressEvent) {
+	if o.Progress != nil {
+		o.Progress(event)
+	}
+}
+
+// prepareWorkDir stages the world for a run with opts. The returned staging
+// directory must be committed on success and rolled back on failure.
+func prepareWorkDir(worldDir, label string, opts WorldOptions) (*stagedWorld, error) {
+	if opts.InPlace && opts.OutputDir != "" {
+		return nil, fmt.Errorf("in-place mode cannot be combined with an output directory")
+	}
+
+	if opts.InPlace {
+		staged, err := stageWorld(worldDir, worldDir)
+		if err != nil {
+			return nil, err
+		}
+		staged.backup = timestampedPath(worldDir, "backup")
+		return staged, nil
+	}
+
+	if opts.OutputDir == "" {
+		staged, err := stageWorld(worldDir, timestampedPath(worldDir, label))
+		if err != nil {
+			return nil, err
+		}
+		opts.logger().Debug("Copied world", "copy_dir", staged.Dir)
+		return staged, nil
+	}
+
+	srcAbs, err := filepath.Abs(worldDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve world directory: %w", err)
+	}
+	dstAbs, err := filepath.Abs(opts.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output directory: %w", err)
+	}
+
+	if dstAbs == srcAbs {
+		return nil, fmt.Errorf("output directory is the world directory, use in-place mode instead")
+	}
+	if archive.IsWithin(dstAbs, srcAbs) {
+		return nil, fmt.Errorf("output directory %s is inside the world directory", opts.OutputDir)
+	}
+	// A forced commit deletes the old output, and the world with it.
+	if archive.IsWithin(srcAbs, dstAbs) {
+		return nil, fmt.Errorf("output directory %s contains the world directory", opts.OutputDir)
+	}
+
+	if _, err := os.Stat(opts.OutputDir); err == nil {
+		if !opts.Force {
+			return nil, fmt.Errorf("output directory %s already exists", opts.OutputDir)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to check output directory: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstAbs), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent of output directory: %w", err)
+	}
+
+	staged, err := stageWorld(worldDir, opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	// An existing directory is only replaced once the new world is complete.
+	staged.force = opts.Force
+	return staged, nil
+}
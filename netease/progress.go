@@ -0,0 +1,63 @@
+package netease
+
+// Logger is the subset of github.com/charmbracelet/log's *Logger used by the
+// package, so callers can pass their own logger or silence output entirely.
+type Logger interface {
+	Debug(msg interface{}, keyvals ...interface{})
+	Info(msg interface{}, keyvals ...interface{})
+	Warn(msg interface{}, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(interface{}, ...interface{}) {}
+func (nopLogger) Info(interface{}, ...interface{})  {}
+func (nopLogger) Warn(interface{}, ...interface{})  {}
+
+type ProgressKind int
+
+const (
+	// ProgressStarted is sent once the files to process are known.
+	ProgressStarted ProgressKind = iota
+	// ProgressFileProcessed is sent after a file was decrypted or encrypted.
+	ProgressFileProcessed
+	// ProgressFileSkipped is sent for files that need no processing.
+	ProgressFileSkipped
+	// ProgressFileVerified is sent with the outcome of checking a decrypted
+	// file when WorldOptions.Verify is set.
+	ProgressFileVerified
+)
+
+func (k ProgressKind) String() string {
+	switch k {
+	case ProgressStarted:
+		return "started"
+	case ProgressFileProcessed:
+		return "processed"
+	case ProgressFileSkipped:
+		return "skipped"
+	case ProgressFileVerified:
+		return "verified"
+	default:
+		return "unknown"
+	}
+}
+
+// ProgressEvent describes the state of a world operation. Done counts both
+// processed and skipped files out of Total. Verified events only carry Path
+// and Check.
+type ProgressEvent struct {
+	Kind  ProgressKind
+	Path  string
+	Done  int
+	Total int
+	Check *FileCheck
+}
+
+// ProgressChannel adapts ch to a WorldOptions.Progress callback. Sends block,
+// so ch must be drained while the operation runs.
+func ProgressChannel(ch chan<- ProgressEvent) func(ProgressEvent) {
+	return func(event ProgressEvent) {
+		ch <- event
+	}
+}
@@ -0,0 +1,168 @@
+package netease
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/yechentide/necrack/archive"
+)
+
+// stagedWorld is a private copy of a world that is modified in a hidden
+// staging directory and only moved to its destination once every file has
+// been processed, so a failed run never leaves a partial world behind.
+type stagedWorld struct {
+	// Dir is the staging directory to modify.
+	Dir string
+
+	src    string
+	dest   string
+	backup string
+	force  bool
+}
+
+// stageWorld copies worldDir into a staging directory next to dest.
+func stageWorld(worldDir, dest string) (*stagedWorld, error) {
+	stagingDir, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	if err := os.Chmod(stagingDir, 0755); err != nil {
+		os.RemoveAll(stagingDir)
+		return nil, fmt.Errorf("failed to set staging directory mode: %w", err)
+	}
+
+	if err := copyDirectory(worldDir, stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return nil, fmt.Errorf("failed to copy world directory: %w", err)
+	}
+
+	return &stagedWorld{Dir: stagingDir, src: worldDir, dest: dest}, nil
+}
+
+// commit moves the staging directory to its destination and returns the
+// destination. In in-place mode the original world is moved to the backup
+// path first and restored if the final rename fails.
+func (s *stagedWorld) commit(opts WorldOptions) (string, error) {
+	var replaced string
+	switch {
+	case s.backup != "":
+		if err := os.Rename(s.dest, s.backup); err != nil {
+			s.rollback(opts)
+			return "", fmt.Errorf("failed to back up world: %w", err)
+		}
+		opts.logger().Info("Created backup", "backup_dir", s.backup)
+
+	case s.force:
+		if _, err := os.Stat(s.dest); err == nil {
+			replaced = s.Dir + ".old"
+			if err := os.Rename(s.dest, replaced); err != nil {
+				s.rollback(opts)
+				return "", fmt.Errorf("failed to move existing output directory: %w", err)
+			}
+		}
+	}
+
+	if err := os.Rename(s.Dir, s.dest); err != nil {
+		restore := s.backup
+		if restore == "" {
+			restore = replaced
+		}
+		if restore != "" {
+			if restoreErr := os.Rename(restore, s.dest); restoreErr != nil {
+				opts.logger().Warn("Failed to restore world", "path", restore, "error", restoreErr)
+			}
+		}
+		s.rollback(opts)
+		return "", fmt.Errorf("failed to move world to %s: %w", s.dest, err)
+	}
+
+	if replaced != "" {
+		if err := os.RemoveAll(replaced); err != nil {
+			opts.logger().Warn("Failed to remove replaced output directory", "path", replaced, "error", err)
+		}
+	}
+
+	if err := syncDir(filepath.Dir(s.dest)); err != nil {
+		return "", fmt.Errorf("failed to sync %s: %w", filepath.Dir(s.dest), err)
+	}
+
+	return s.dest, nil
+}
+
+// rollback removes the staging directory. The destination is never touched.
+func (s *stagedWorld) rollback(opts WorldOptions) {
+	if err := os.RemoveAll(s.Dir); err != nil {
+		opts.logger().Warn("Failed to remove staging directory", "path", s.Dir, "error", err)
+		return
+	}
+	opts.logger().Debug("Removed staging directory", "path", s.Dir)
+}
+
+// progress wraps report so events name files at their final location rather
+// than inside the staging directory.
+func (s *stagedWorld) progress(report func(ProgressEvent)) func(ProgressEvent) {
+	if report == nil {
+		return nil
+	}
+	return func(event ProgressEvent) {
+		if rel, err := filepath.Rel(s.Dir, event.Path); err == nil && event.Path != "" {
+			event.Path = filepath.Join(s.dest, rel)
+		}
+		report(event)
+	}
+}
+
+// sourcePaths points the FileErrors in err at the files of the source world
+// instead of their staged copies, which rollback deletes, and returns err.
+func (s *stagedWorld) sourcePaths(err error) error {
+	walkErrors(err, func(err error) {
+		if fileErr, ok := err.(*FileError); ok && archive.IsWithin(fileErr.Path, s.Dir) {
+			rel, _ := filepath.Rel(s.Dir, fileErr.Path)
+			fileErr.Path = filepath.Join(s.src, rel)
+		}
+	})
+	return err
+}
+
+// walkErrors calls visit for err and every error it wraps.
+func walkErrors(err error, visit func(error)) {
+	if err == nil {
+		return
+	}
+	visit(err)
+	switch err := err.(type) {
+	case interface{ Unwrap() error }:
+		walkErrors(err.Unwrap(), visit)
+	case interface{ Unwrap() []error }:
+		for _, err := range err.Unwrap() {
+			walkErrors(err, visit)
+		}
+	}
+}
+
+// timestampedPath returns the path next to worldDir used for copies and
+// backups with the given label.
+func timestampedPath(worldDir, label string) string {
+	timestamp := time.Now().Format("20060102_150405")
+	return filepath.Join(filepath.Dir(worldDir), filepath.Base(worldDir)+"_"+label+"_"+timestamp)
+}
+
+// syncDir flushes directory entries, so renames survive a crash. Windows
+// cannot sync directories and commits renames on its own.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
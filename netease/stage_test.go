@@ -0,0 +1,50 @@
+package netease
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailedWorldReportsSourcePaths(t *testing.T) {
+	worldDir := filepath.Join(t.TempDir(), "world")
+	dbDir := filepath.Join(worldDir, "db")
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"CURRENT":    []byte("MANIFEST-000002\n"),
+		"000005.ldb": buildTable()[:60], // no footer
+	}
+	for name, plain := range files {
+		if err := os.WriteFile(filepath.Join(dbDir, name), encryptXOR(plain, testKey), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts WorldOptions
+	}{
+		{name: "copy", opts: WorldOptions{OutputDir: filepath.Join(t.TempDir(), "out")}},
+		{name: "in place", opts: WorldOptions{InPlace: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Key = testKey
+			tt.opts.Verify = true
+			_, err := DecryptWorldDBWithOptions(worldDir, tt.opts)
+
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("error = %v, want a *FileError", err)
+			}
+			if want := filepath.Join(dbDir, "000005.ldb"); fileErr.Path != want {
+				t.Errorf("path = %s, want %s", fileErr.Path, want)
+			}
+			if _, err := os.Stat(fileErr.Path); err != nil {
+				t.Errorf("reported file does not exist: %v", err)
+			}
+		})
+	}
+}
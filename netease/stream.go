@@ -0,0 +1,186 @@
+package netease
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// HeaderSize is the length of the header that precedes files encrypted with
+// the built-in codecs.
+const HeaderSize = 4
+
+// XORStream is the cipher.Stream of the current NetEase format. It XORs data
+// with a repeating key and keeps its position across calls, so a file can be
+// processed in chunks of any size.
+type XORStream struct {
+	key []byte
+	pos int
+}
+
+var _ cipher.Stream = (*XORStream)(nil)
+
+func NewXORStream(key []byte) *XORStream {
+	return &XORStream{key: key}
+}
+
+func (s *XORStream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("necrack/netease: output smaller than input")
+	}
+
+	keyLen := len(s.key)
+	if keyLen == 0 {
+		copy(dst, src)
+		return
+	}
+
+	for i := range src {
+		dst[i] = src[i] ^ s.key[s.pos]
+		s.pos++
+		if s.pos == keyLen {
+			s.pos = 0
+		}
+	}
+}
+
+// NewDecryptReader consumes the header from r and returns a reader that
+// yields the decrypted body. The codec is detected from the header; key must
+// match it.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	codec := DetectCodec(header)
+	if codec == nil {
+		return nil, ValidateDecryptableFile(header)
+	}
+
+	stream, err := codec.NewDecrypter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipher.StreamReader{S: stream, R: r}, nil
+}
+
+// NewEncryptWriter writes the header of DefaultCodec to w and returns a
+// writer that encrypts everything written to it.
+func NewEncryptWriter(w io.Writer, key []byte) (io.Writer, error) {
+	return NewEncryptWriterWithCodec(w, DefaultCodec, key)
+}
+
+// NewEncryptWriterWithCodec is like NewEncryptWriter but encrypts with codec.
+func NewEncryptWriterWithCodec(w io.Writer, codec Codec, key []byte) (io.Writer, error) {
+	stream, err := codec.NewEncrypter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(codec.Header()); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return &cipher.StreamWriter{S: stream, W: w}, nil
+}
+
+func readHeaderType(path string) (HeaderType, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return HeaderTypeUnknown, &FileError{Path: path, Err: err}
+	}
+	defer file.Close()
+
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return HeaderTypeUnknown, &FileError{Path: path, Err: fmt.Errorf("failed to read header: %w", err)}
+	}
+
+	return identifyHeader(header[:n]), nil
+}
+
+// rewriteFile replaces path with the output of transform, streaming through
+// a temporary file in the same directory that is synced before it is renamed
+// over path. Errors are returned as a *FileError for path.
+func rewriteFile(path string, transform func(dst io.Writer, src io.Reader) error) error {
+	if err := rewriteFileContents(path, transform); err != nil {
+		return &FileError{Path: path, Err: err}
+	}
+	return nil
+}
+
+func rewriteFileContents(path string, transform func(dst io.Writer, src io.Reader) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := transform(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, info.Mode()); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+
+	src.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	return nil
+}
+
+func decryptFileInPlace(path string, key []byte) error {
+	return rewriteFile(path, func(dst io.Writer, src io.Reader) error {
+		reader, err := NewDecryptReader(src, key)
+		if err != nil {
+			return fmt.Errorf("not decryptable: %w", err)
+		}
+		if _, err := io.Copy(dst, reader); err != nil {
+			return fmt.Errorf("failed to decrypt: %w", err)
+		}
+		return nil
+	})
+}
+
+func encryptFileInPlace(path string, codec Codec, key []byte) error {
+	return rewriteFile(path, func(dst io.Writer, src io.Reader) error {
+		writer, err := NewEncryptWriterWithCodec(dst, codec, key)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, src); err != nil {
+			return fmt.Errorf("failed to encrypt: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,261 @@
+package netease
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckStatus is the outcome of checking a single db file.
+type CheckStatus string
+
+const (
+	// CheckPassed means the file has the expected LevelDB structure.
+	CheckPassed CheckStatus = "passed"
+	// CheckWarning means the file is usable but not entirely intact, such as
+	// a log with a torn last record.
+	CheckWarning CheckStatus = "warning"
+	// CheckFailed means the file does not have the expected structure.
+	CheckFailed CheckStatus = "failed"
+	// CheckUnverified means the file has no structure that can be checked.
+	CheckUnverified CheckStatus = "unverified"
+	// CheckSkipped means the file was not checked, e.g. it is not encrypted.
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// FileCheck is the result of checking a single db file.
+type FileCheck struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// VerifyKey decrypts every encrypted file of a db directory in memory and
+// checks that the result is valid LevelDB data.
+func VerifyKey(dbDir string, key []byte) ([]FileCheck, error) {
+	return verifyKeyFS(os.DirFS(dbDir), key)
+}
+
+func verifyKeyFS(fsys fs.FS, key []byte) ([]FileCheck, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read db directory: %w", err)
+	}
+
+	var checks []FileCheck
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		checks = append(checks, verifyFileKey(fsys, entry.Name(), key))
+	}
+
+	return checks, nil
+}
+
+// verifyFileKey decrypts a file while it is checked, so only a block of it
+// is held in memory.
+func verifyFileKey(fsys fs.FS, name string, key []byte) FileCheck {
+	file, size, err := openSized(fsys, name)
+	if err != nil {
+		return FileCheck{Name: name, Status: CheckFailed, Detail: err.Error()}
+	}
+	defer file.Close()
+
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FileCheck{Name: name, Status: CheckFailed, Detail: err.Error()}
+	}
+
+	codec := DetectCodec(header[:n])
+	if codec == nil {
+		return FileCheck{Name: name, Status: CheckSkipped, Detail: "not encrypted"}
+	}
+
+	stream, err := codec.NewDecrypter(key)
+	if err != nil {
+		return FileCheck{Name: name, Status: CheckFailed, Detail: err.Error()}
+	}
+
+	return checkPlainReader(name, cipher.StreamReader{S: stream, R: file}, size-HeaderSize)
+}
+
+// openSized opens a file of fsys and returns its size.
+func openSized(fsys fs.FS, name string) (fs.File, int64, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// verifyCurrentKey checks that key decrypts the CURRENT file of a db
+// directory into a MANIFEST name. Nothing is checked when CURRENT is missing
+// or not encrypted with codec.
+func verifyCurrentKey(dbFS fs.FS, codec Codec, key []byte) error {
+	data, err := fs.ReadFile(dbFS, "CURRENT")
+	if err != nil || DetectCodec(data) != codec {
+		if len(key) != codec.KeySize() {
+			return fmt.Errorf("key must be exactly %d bytes, got %d bytes", codec.KeySize(), len(key))
+		}
+		return nil
+	}
+
+	plain, err := decryptBody(codec, data[HeaderSize:], key)
+	if err != nil {
+		return err
+	}
+	if err := checkCurrent(plain); err != nil {
+		return fmt.Errorf("%w: %w", ErrKeyMismatch, err)
+	}
+	return nil
+}
+
+// decryptBody decrypts the body of a file encrypted with codec.
+func decryptBody(codec Codec, body, key []byte) ([]byte, error) {
+	stream, err := codec.NewDecrypter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(body))
+	stream.XORKeyStream(plain, body)
+	return plain, nil
+}
+
+// maxCurrentSize bounds how much of a CURRENT file is read. Real ones hold
+// a single MANIFEST name.
+const maxCurrentSize = 1024
+
+// checkPlainReader checks decrypted db file content of the given size read
+// from r according to the name of the file. Only a bounded part of the file
+// is held in memory: log files are read a block at a time and only the
+// footer of tables is kept.
+func checkPlainReader(name string, r io.Reader, size int64) FileCheck {
+	check := FileCheck{Name: name, Status: CheckPassed}
+
+	switch {
+	case name == "CURRENT":
+		if size > maxCurrentSize {
+			check.Status, check.Detail = CheckFailed, "CURRENT is too large"
+			break
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			check.Status, check.Detail = CheckFailed, err.Error()
+			break
+		}
+		if err := checkCurrent(data); err != nil {
+			check.Status, check.Detail = CheckFailed, err.Error()
+		}
+
+	case strings.HasPrefix(name, "MANIFEST-") || filepath.Ext(name) == ".log":
+		records, issue, err := checkLogReader(r, size)
+		switch {
+		case err != nil:
+			check.Status, check.Detail = CheckFailed, err.Error()
+		case issue == nil:
+		case issue.Truncated && records > 0:
+			check.Status = CheckWarning
+			check.Detail = fmt.Sprintf("%d valid records, then %s", records, issue)
+		default:
+			check.Status, check.Detail = CheckFailed, issue.Error()
+		}
+
+	case isTableFile(name):
+		if size < tableFooterSize {
+			check.Status, check.Detail = CheckFailed, "table is smaller than its footer"
+			break
+		}
+		footer, err := readTail(r, size, tableFooterSize)
+		if err != nil {
+			check.Status, check.Detail = CheckFailed, err.Error()
+			break
+		}
+		if err := checkTableFooter(footer, int(size)); err != nil {
+			check.Status, check.Detail = CheckFailed, err.Error()
+		}
+
+	default:
+		check.Status = CheckUnverified
+	}
+
+	return check
+}
+
+// readTail returns the last n bytes of the size bytes read from r. Readers
+// that cannot seek, such as decrypting ones, are read through.
+func readTail(r io.Reader, size int64, n int) ([]byte, error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(size-int64(n), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, r, size-int64(n)); err != nil {
+		return nil, err
+	}
+
+	tail := make([]byte, n)
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return nil, err
+	}
+	return tail, nil
+}
+
+// checkPlainPath checks a decrypted file on disk.
+func checkPlainPath(path string) FileCheck {
+	name := filepath.Base(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return FileCheck{Name: name, Status: CheckFailed, Detail: err.Error()}
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return FileCheck{Name: name, Status: CheckFailed, Detail: err.Error()}
+	}
+	return checkPlainReader(name, file, info.Size())
+}
+
+// verifyDecryptedFile checks a file that was decrypted in place and reports
+// the outcome. Warnings are logged, failures are returned as errors. Only
+// CURRENT and the MANIFEST reveal a wrong key; every file is decrypted with
+// the same key, so other files that fail are damaged.
+func verifyDecryptedFile(path string, opts WorldOptions) error {
+	check := checkPlainPath(path)
+
+	opts.report(ProgressEvent{Kind: ProgressFileVerified, Path: path, Check: &check})
+
+	switch check.Status {
+	case CheckFailed:
+		cause := ErrCorrupted
+		if name := filepath.Base(path); name == "CURRENT" || strings.HasPrefix(name, "MANIFEST-") {
+			cause = ErrKeyMismatch
+		}
+		return &FileError{Path: path, Err: fmt.Errorf("%w: failed verification: %s", cause, check.Detail)}
+	case CheckWarning:
+		opts.logger().Warn("Decrypted file is damaged", "path", path, "detail", check.Detail)
+	}
+
+	return nil
+}
+
+// CountChecks returns how many checks ended with each status.
+func CountChecks(checks []FileCheck) map[CheckStatus]int {
+	counts := make(map[CheckStatus]int)
+	for _, check := range checks {
+		counts[check.Status]++
+	}
+	return counts
+}
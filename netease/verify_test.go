@@ -0,0 +1,46 @@
+package netease
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDecryptedFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "CURRENT", data: []byte("MANIFEST-000002\n")},
+		{name: "CURRENT", data: []byte("garbage"), want: ErrKeyMismatch},
+		{name: "MANIFEST-000002", data: buildLog([]byte("record"))[:10], want: ErrKeyMismatch},
+		{name: "000005.ldb", data: buildTable()},
+		{name: "000005.ldb", data: buildTable()[:60], want: ErrCorrupted},
+		{name: "000003.log", data: buildLog([]byte("record"))[:10], want: ErrCorrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := verifyDecryptedFile(path, WorldOptions{})
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("error = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if tt.want == ErrCorrupted && errors.Is(err, ErrKeyMismatch) {
+				t.Errorf("error = %v, reported as a key mismatch", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package netease
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// listFiles returns every regular file below dir in lexical order.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// processFiles runs process for every file on a bounded pool of workers.
+// process reports whether the file was changed or skipped. No new files are
+// started once one has failed or the context is done. The returned error joins
+// the failures in the order of files, so the result does not depend on
+// scheduling.
+func processFiles(files []string, opts WorldOptions, process func(path string) (bool, error)) error {
+	ctx := opts.context()
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > len(files) {
+		jobs = len(files)
+	}
+
+	opts.report(ProgressEvent{Kind: ProgressStarted, Total: len(files)})
+
+	errs := make([]error, len(files))
+	var done atomic.Int64
+	indexes := make(chan int)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var wg sync.WaitGroup
+
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				processed, err := process(files[i])
+				if err != nil {
+					errs[i] = err
+					stopOnce.Do(func() { close(stop) })
+					continue
+				}
+
+				kind := ProgressFileSkipped
+				if processed {
+					kind = ProgressFileProcessed
+				}
+				opts.report(ProgressEvent{
+					Kind:  kind,
+					Path:  files[i],
+					Done:  int(done.Add(1)),
+					Total: len(files),
+				})
+			}
+		}()
+	}
+
+	var canceled error
+feed:
+	for i := range files {
+		select {
+		case <-stop:
+			break feed
+		case <-ctx.Done():
+			canceled = ctx.Err()
+			break feed
+		default:
+		}
+
+		select {
+		case indexes <- i:
+		case <-stop:
+			break feed
+		case <-ctx.Done():
+			canceled = ctx.Err()
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return canceled
+	case 1:
+		return failed[0]
+	default:
+		return fmt.Errorf("%d files failed: %w", len(failed), errors.Join(failed...))
+	}
+}
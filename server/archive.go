@@ -0,0 +1,251 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/yechentide/necrack/archive"
+	"github.com/yechentide/necrack/netease"
+)
+
+// ResponseMode selects which worlds the returned archive contains.
+type ResponseMode string
+
+const (
+	// ModeDecrypted returns only the decrypted worlds, each under the path
+	// it had in the upload.
+	ModeDecrypted ResponseMode = "decrypted"
+	// ModeEncrypted is ModeDecrypted for encryption requests.
+	ModeEncrypted ResponseMode = "encrypted"
+	// ModeBoth returns the upload with a timestamped processed copy next to
+	// every original world. A world at the root of the upload is returned
+	// under rootWorldName.
+	ModeBoth ResponseMode = "both"
+	// ModeOriginalLayout returns every file of the upload with each world
+	// replaced by its processed version.
+	ModeOriginalLayout ResponseMode = "original-layout"
+)
+
+// parseResponseMode parses the mode query parameter of a request for op. An
+// empty value selects the mode that returns only the processed worlds.
+func parseResponseMode(value string, op *operation) (ResponseMode, error) {
+	switch mode := ResponseMode(value); mode {
+	case "":
+		return ResponseMode(op.Label), nil
+	case ResponseMode(op.Label), ModeBoth, ModeOriginalLayout:
+		return mode, nil
+	default:
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("Unknown mode %q, expected %s, both or original-layout", value, op.Label), nil)
+	}
+}
+
+// World statuses used in ArchiveReport.
+const (
+	WorldDecrypted = "decrypted"
+	WorldEncrypted = "encrypted"
+	WorldFailed    = "failed"
+)
+
+// WorldReport describes the outcome for a single world of an upload.
+type WorldReport struct {
+	// Path is the world directory relative to the root of the upload.
+	Path    string                     `json:"path"`
+	Status  string                     `json:"status"`
+	Headers map[netease.HeaderType]int `json:"headers,omitempty"`
+	// Key is the hex key the world was decrypted or encrypted with. An
+	// encryption request with key=original reuses it.
+	Key            string `json:"key,omitempty"`
+	VerifiedFiles  int    `json:"verified_files"`
+	VerifyWarnings int    `json:"verify_warnings"`
+	Code           string `json:"code,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ArchiveReport is written to report.json in every returned archive.
+type ArchiveReport struct {
+	Worlds []WorldReport `json:"worlds"`
+	Failed int           `json:"failed"`
+}
+
+// reportFile is the name of the ArchiveReport in returned archives.
+const reportFile = "report.json"
+
+// archiveResult describes the output of processArchive.
+type archiveResult struct {
+	ZipPath  string
+	Report   ArchiveReport
+	Verified int
+	Warnings int
+}
+
+// rootWorldName is the directory that holds a world uploaded at the root of
+// the archive when it is returned together with its processed copy.
+const rootWorldName = "world"
+
+// processArchive extracts the uploaded zip at zipPath into workDir within
+// the limits of cfg, runs op on every world in it and zips the result
+// selected by mode into workDir. Worlds that fail are listed in the report
+// and left out of the processed output; processArchive only fails when no
+// world could be processed. progress, if not nil, receives the events of
+// every world together with its index.
+func processArchive(ctx context.Context, cfg Config, op *operation, workDir, zipPath string, mode ResponseMode, logger *log.Logger, progress func(world, worlds int, event netease.ProgressEvent)) (*archiveResult, error) {
+	extractDir := filepath.Join(workDir, "extracted")
+	if err := archive.Extract(zipPath, extractDir, cfg.extractLimits()); err != nil {
+		// Limit and safety errors are ours and name the offending entry.
+		switch {
+		case errors.Is(err, archive.ErrLimit):
+			return nil, newAPIError(http.StatusRequestEntityTooLarge, CodeArchiveLimit, "Failed to extract ZIP: "+err.Error(), err)
+		case errors.Is(err, archive.ErrUnsafeEntry):
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidArchive, "Failed to extract ZIP: "+err.Error(), err)
+		case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrAlgorithm), errors.Is(err, zip.ErrChecksum):
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidArchive, "Upload is not a valid ZIP archive", err)
+		}
+		return nil, internalError("Failed to extract ZIP", err)
+	}
+
+	worldDirs, err := archive.FindWorlds(extractDir)
+	if err != nil {
+		return nil, internalError("Failed to find world directories", err)
+	}
+
+	if len(worldDirs) == 0 {
+		logger.Warn("No world directories found in ZIP")
+		return nil, newAPIError(http.StatusBadRequest, CodeNoWorlds, "No world directories found in ZIP", nil)
+	}
+
+	logger.Info("Found world directories", "count", len(worldDirs), "directories", worldDirs)
+
+	// In ModeBoth the copies are written next to their worlds, so that they
+	// end up in the archive. A world at the root of the upload has no place
+	// for a sibling, so the upload is moved into a directory first. Reports
+	// keep the paths of the upload.
+	uploadDir := extractDir
+	if mode == ModeBoth && slices.Contains(worldDirs, extractDir) {
+		nestedDir := filepath.Join(workDir, "nested")
+		if err := os.Mkdir(nestedDir, 0755); err != nil {
+			return nil, internalError("Failed to prepare world directory", err)
+		}
+		if err := os.Rename(extractDir, filepath.Join(nestedDir, rootWorldName)); err != nil {
+			return nil, internalError("Failed to prepare world directory", err)
+		}
+		for i, worldDir := range worldDirs {
+			relPath, err := filepath.Rel(extractDir, worldDir)
+			if err != nil {
+				return nil, internalError("Failed to resolve world directory", err)
+			}
+			worldDirs[i] = filepath.Join(nestedDir, rootWorldName, relPath)
+		}
+		uploadDir = filepath.Join(nestedDir, rootWorldName)
+		extractDir = nestedDir
+	}
+	timestamp := time.Now().Format("20060102_150405")
+
+	// Except in ModeBoth, worlds are processed into a separate tree that
+	// mirrors the paths of the upload.
+	outputRoot := filepath.Join(workDir, op.Label)
+	outputDirs := make([]string, len(worldDirs))
+	result := &archiveResult{}
+	var firstErr *APIError
+
+	for i, worldDir := range worldDirs {
+		relPath, err := filepath.Rel(uploadDir, worldDir)
+		if err != nil {
+			return nil, internalError("Failed to resolve world directory", err)
+		}
+		if mode == ModeBoth {
+			outputDirs[i] = worldDir + "_" + op.Label + "_" + timestamp
+		} else {
+			outputDirs[i] = filepath.Join(outputRoot, relPath)
+		}
+
+		report := WorldReport{Path: filepath.ToSlash(relPath), Status: op.Label}
+		apiErr := op.Process(worldTask{
+			Context:   ctx,
+			UploadDir: uploadDir,
+			WorldDir:  worldDir,
+			OutputDir: outputDirs[i],
+			Report:    &report,
+			Logger:    logger,
+			Progress: func(event netease.ProgressEvent) {
+				if progress != nil {
+					progress(i, len(worldDirs), event)
+				}
+			},
+		})
+
+		if apiErr != nil {
+			if apiErr.Code == CodeCanceled {
+				return nil, apiErr
+			}
+
+			logger.Error("Failed to process world", "operation", op.Name, "world_dir", worldDir, "code", apiErr.Code, "error", apiErr.Err)
+			report.Status, report.Code, report.Error = WorldFailed, apiErr.Code, apiErr.Message
+			result.Report.Failed++
+			outputDirs[i] = ""
+			if firstErr == nil {
+				firstErr = apiErr
+			}
+		} else {
+			result.Verified += report.VerifiedFiles
+			result.Warnings += report.VerifyWarnings
+		}
+
+		result.Report.Worlds = append(result.Report.Worlds, report)
+	}
+
+	if result.Report.Failed == len(worldDirs) {
+		if len(worldDirs) > 1 {
+			firstErr.Message = fmt.Sprintf("All %d worlds failed. %s", len(worldDirs), firstErr.Message)
+		}
+		return nil, firstErr
+	}
+
+	zipRoot := extractDir
+	switch mode {
+	case ResponseMode(op.Label):
+		zipRoot = outputRoot
+	case ModeOriginalLayout:
+		for i := range worldDirs {
+			if outputDirs[i] == "" {
+				continue
+			}
+			if err := replaceDirectory(worldDirs[i], outputDirs[i]); err != nil {
+				return nil, internalError("Failed to replace world", err)
+			}
+		}
+	}
+
+	reportData, err := json.MarshalIndent(result.Report, "", "  ")
+	if err != nil {
+		return nil, internalError("Failed to encode report", err)
+	}
+	if err := os.WriteFile(filepath.Join(zipRoot, reportFile), reportData, 0644); err != nil {
+		return nil, internalError("Failed to write report", err)
+	}
+
+	outputZipPath := filepath.Join(workDir, op.Label+".zip")
+	if err := archive.Create(zipRoot, outputZipPath); err != nil {
+		return nil, internalError("Failed to create output ZIP", err)
+	}
+
+	result.ZipPath = outputZipPath
+	return result, nil
+}
+
+// replaceDirectory removes dst and moves src to its place.
+func replaceDirectory(dst, src string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name string
+	// Quota is the number of processing requests allowed per quota window.
+	// Zero means unlimited.
+	Quota int
+}
+
+// Authenticator checks the credentials of a request. It returns nil and no
+// error when the request carries no credentials of its kind, and
+// errInvalidCredentials when they are wrong.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate value sent with 401 responses.
+	Challenge() string
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// credential is a secret and the principal it authenticates.
+type credential struct {
+	principal Principal
+	// digest is the SHA-256 of the secret, so secrets of any length compare
+	// in constant time.
+	digest [sha256.Size]byte
+}
+
+func (c *credential) matches(secret string) bool {
+	digest := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(c.digest[:], digest[:]) == 1
+}
+
+// loadCredentials reads a file with one credential per line:
+//
+//	<name> <secret> [quota]
+//
+// Secrets may be given as sha256:<hex digest> instead of in plain text.
+// Blank lines and lines starting with # are ignored.
+func loadCredentials(path string) (map[string]*credential, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	creds := make(map[string]*credential)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected <name> <secret> [quota]", path, lineNo)
+		}
+
+		cred := &credential{principal: Principal{Name: fields[0]}}
+		if digestHex, ok := strings.CutPrefix(fields[1], "sha256:"); ok {
+			digest, err := hex.DecodeString(digestHex)
+			if err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("%s:%d: invalid sha256 digest", path, lineNo)
+			}
+			copy(cred.digest[:], digest)
+		} else {
+			cred.digest = sha256.Sum256([]byte(fields[1]))
+		}
+
+		if len(fields) == 3 {
+			quota, err := strconv.Atoi(fields[2])
+			if err != nil || quota < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid quota %q", path, lineNo, fields[2])
+			}
+			cred.principal.Quota = quota
+		}
+
+		if _, ok := creds[cred.principal.Name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate name %q", path, lineNo, cred.principal.Name)
+		}
+		creds[cred.principal.Name] = cred
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("%s: no credentials", path)
+	}
+	return creds, nil
+}
+
+// TokenAuth authenticates requests with an "Authorization: Bearer <token>"
+// header.
+type TokenAuth struct {
+	creds map[string]*credential
+}
+
+// LoadTokenAuth reads API tokens from path, one "<name> <token> [quota]"
+// per line. The name identifies the client in logs and quotas.
+func LoadTokenAuth(path string) (*TokenAuth, error) {
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %w", err)
+	}
+	return &TokenAuth{creds: creds}, nil
+}
+
+func (a *TokenAuth) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	// Every token is compared, so the time taken does not reveal which
+	// one matched.
+	var match *credential
+	for _, cred := range a.creds {
+		if cred.matches(token) {
+			match = cred
+		}
+	}
+	if match == nil {
+		return nil, errInvalidCredentials
+	}
+	principal := match.principal
+	return &principal, nil
+}
+
+func (a *TokenAuth) Challenge() string {
+	return `Bearer realm="necrack"`
+}
+
+// BasicAuth authenticates requests with HTTP basic authentication.
+type BasicAuth struct {
+	creds map[string]*credential
+}
+
+// LoadBasicAuth reads users from path, one "<user> <password> [quota]" per
+// line.
+func LoadBasicAuth(path string) (*BasicAuth, error) {
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	return &BasicAuth{creds: creds}, nil
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	cred, ok := a.creds[user]
+	if !ok || !cred.matches(password) {
+		return nil, errInvalidCredentials
+	}
+	principal := cred.principal
+	return &principal, nil
+}
+
+func (a *BasicAuth) Challenge() string {
+	return `Basic realm="necrack", charset="UTF-8"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the client authenticated by Auth, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Auth is middleware that requires every request to be authenticated by one
+// of its authenticators and enforces the quotas of the principals.
+type Auth struct {
+	authenticators []Authenticator
+	window         time.Duration
+
+	mu     sync.Mutex
+	usage  map[string]int
+	resets time.Time
+}
+
+// NewAuth returns middleware using authenticators. Quotas are counted over
+// fixed windows of the given length.
+func NewAuth(window time.Duration, authenticators ...Authenticator) *Auth {
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	return &Auth{
+		authenticators: authenticators,
+		window:         window,
+		usage:          make(map[string]int),
+	}
+}
+
+// Require wraps next so that it only serves authenticated requests.
+func (a *Auth) Require(next http.Handler) http.Handler {
+	return a.wrap(next, false)
+}
+
+// Metered wraps next like Require and additionally counts every request
+// against the quota of the principal. Requests over quota are rejected with
+// 429.
+func (a *Auth) Metered(next http.Handler) http.Handler {
+	return a.wrap(next, true)
+}
+
+func (a *Auth) wrap(next http.Handler, metered bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil || principal == nil {
+			log.Warn("Unauthorized request", "client_ip", r.RemoteAddr, "path", r.URL.Path, "error", err)
+			for _, authenticator := range a.authenticators {
+				w.Header().Add("WWW-Authenticate", authenticator.Challenge())
+			}
+			message := "Authentication required"
+			if err != nil {
+				message = "Invalid credentials"
+			}
+			writeError(w, newAPIError(http.StatusUnauthorized, CodeUnauthorized, message, err))
+			return
+		}
+
+		if metered {
+			if retryAfter, ok := a.consume(principal); !ok {
+				log.Warn("Quota exceeded", "client_ip", r.RemoteAddr, "principal", principal.Name, "quota", principal.Quota)
+				apiErr := newAPIError(http.StatusTooManyRequests, CodeQuotaExceeded,
+					fmt.Sprintf("Quota of %d requests per %s exceeded", principal.Quota, a.window), nil)
+				apiErr.RetryAfter = retryAfter
+				writeError(w, apiErr)
+				return
+			}
+		}
+
+		log.Debug("Request authenticated", "client_ip", r.RemoteAddr, "principal", principal.Name)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+func (a *Auth) authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil || principal != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
+
+// consume counts a request of principal. When the quota is used up, it
+// returns false and the time until the window resets.
+func (a *Auth) consume(principal *Principal) (time.Duration, bool) {
+	if principal.Quota == 0 {
+		return 0, true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if !now.Before(a.resets) {
+		clear(a.usage)
+		a.resets = now.Add(a.window)
+	}
+
+	if a.usage[principal.Name] >= principal.Quota {
+		return a.resets.Sub(now), false
+	}
+	a.usage[principal.Name]++
+	return 0, true
+}
@@ -0,0 +1,60 @@
+package server
+
+import "github.com/yechentide/necrack/archive"
+
+// Defaults used for the zero fields of Config.
+const (
+	DefaultMaxUploadSize       = 1 << 30
+	DefaultMaxExtractSize      = archive.DefaultMaxSize
+	DefaultMaxExtractFiles     = archive.DefaultMaxFiles
+	DefaultMaxCompressionRatio = archive.DefaultMaxRatio
+	DefaultMaxExtractDepth     = archive.DefaultMaxDepth
+)
+
+// Config controls the limits of the HTTP handlers. Zero values use the
+// defaults.
+type Config struct {
+	// MaxUploadSize is the largest accepted request body in bytes.
+	MaxUploadSize int64
+
+	// MaxExtractSize is the largest total uncompressed size of an archive
+	// in bytes.
+	MaxExtractSize int64
+
+	// MaxExtractFiles is the largest number of entries in an archive.
+	MaxExtractFiles int
+
+	// MaxCompressionRatio is the largest ratio between the uncompressed and
+	// compressed size of a single entry. Zero-filled LevelDB files come close
+	// to the 1032:1 maximum of deflate, so lower values may reject real
+	// worlds.
+	MaxCompressionRatio int
+
+	// MaxExtractDepth is the largest number of path elements of an entry.
+	MaxExtractDepth int
+
+	// TempDir is where uploads are extracted and processed. Empty uses the
+	// default directory for temporary files.
+	TempDir string
+
+	// MinFreeDisk is the number of bytes that must stay free in TempDir
+	// after an upload is saved. Uploads that would use up this space are
+	// rejected with 503. Zero disables the check.
+	MinFreeDisk int64
+}
+
+func (c Config) maxUploadSize() int64 {
+	if c.MaxUploadSize <= 0 {
+		return DefaultMaxUploadSize
+	}
+	return c.MaxUploadSize
+}
+
+func (c Config) extractLimits() archive.Limits {
+	return archive.Limits{
+		MaxSize:  c.MaxExtractSize,
+		MaxFiles: c.MaxExtractFiles,
+		MaxRatio: c.MaxCompressionRatio,
+		MaxDepth: c.MaxExtractDepth,
+	}
+}
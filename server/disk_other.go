@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package server
+
+import "errors"
+
+// freeDiskSpace is not implemented on this platform, so the disk space
+// guard is disabled.
+func freeDiskSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
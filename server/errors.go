@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Machine-readable error codes of APIError.
+const (
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeUploadTooLarge       = "UPLOAD_TOO_LARGE"
+	CodeInvalidArchive       = "INVALID_ARCHIVE"
+	CodeArchiveLimit         = "ARCHIVE_LIMIT_EXCEEDED"
+	CodeNoWorlds             = "NO_WORLDS"
+	CodeNotEncrypted         = "NOT_ENCRYPTED"
+	CodeUnsupportedLegacy    = "UNSUPPORTED_LEGACY"
+	CodeKeyDerivationFailed  = "KEY_DERIVATION_FAILED"
+	CodeVerificationFailed   = "VERIFICATION_FAILED"
+	CodeCorruptedWorld       = "CORRUPTED_WORLD"
+	CodeDecryptionFailed     = "DECRYPTION_FAILED"
+	CodeNotVanilla           = "NOT_VANILLA"
+	CodeInvalidKey           = "INVALID_KEY"
+	CodeMissingKey           = "MISSING_KEY"
+	CodeEncryptionFailed     = "ENCRYPTION_FAILED"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeQuotaExceeded        = "QUOTA_EXCEEDED"
+	CodeJobNotFound          = "JOB_NOT_FOUND"
+	CodeJobNotReady          = "JOB_NOT_READY"
+	CodeQueueFull            = "QUEUE_FULL"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeServerBusy           = "SERVER_BUSY"
+	CodeInsufficientStorage  = "INSUFFICIENT_STORAGE"
+	CodeCanceled             = "CANCELED"
+	CodeInternal             = "INTERNAL_ERROR"
+)
+
+// APIError is an error reported to clients. Message never contains internal
+// error text; Err keeps the cause for logging.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+	// RetryAfter is sent as the Retry-After header when positive.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// errorResponse is the body of every failed request.
+type errorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func newAPIError(status int, code, message string, err error) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Err: err}
+}
+
+// internalError hides err from clients behind message.
+func internalError(message string, err error) *APIError {
+	return newAPIError(http.StatusInternalServerError, CodeInternal, message, err)
+}
+
+// writeError reports err to the client as JSON with the status it carries.
+// Errors that are not an *APIError are reported as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		log.Error("Unexpected error", "error", err)
+		apiErr = internalError("Internal server error", err)
+	}
+
+	if apiErr.RetryAfter > 0 {
+		// Rounded up, so clients never retry too early.
+		w.Header().Set("Retry-After", strconv.Itoa(int((apiErr.RetryAfter+time.Second-1)/time.Second)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiErr})
+}
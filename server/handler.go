@@ -1,59 +1,84 @@
 package server
 
 import (
-	"archive/zip"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/charmbracelet/log"
-	"github.com/yechentide/necrack/netease"
 )
 
+// DecryptHandler decrypts an uploaded archive with the default Config.
 func DecryptHandler(w http.ResponseWriter, r *http.Request) {
+	NewDecryptHandler(Config{})(w, r)
+}
+
+// NewDecryptHandler returns a handler that decrypts the uploaded archive
+// within the limits of cfg and responds with the decrypted archive.
+func NewDecryptHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveOperation(w, r, cfg, func() (*operation, error) {
+			return decryptOperation, nil
+		})
+	}
+}
+
+// EncryptHandler encrypts an uploaded archive with the default Config.
+func EncryptHandler(w http.ResponseWriter, r *http.Request) {
+	NewEncryptHandler(Config{})(w, r)
+}
+
+// NewEncryptHandler returns a handler that encrypts the vanilla worlds of the
+// uploaded archive within the limits of cfg and responds with the encrypted
+// archive. The codec and key query parameters select the encryption, see
+// newEncryptOperation.
+func NewEncryptHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveOperation(w, r, cfg, func() (*operation, error) {
+			return newEncryptOperation(r.URL.Query().Get("codec"), r.URL.Query().Get("key"))
+		})
+	}
+}
+
+// serveOperation runs the operation returned by newOp on the uploaded
+// archive and responds with the processed archive.
+func serveOperation(w http.ResponseWriter, r *http.Request, cfg Config, newOp func() (*operation, error)) {
 	start := time.Now()
 	requestID := generateRequestID()
 	logger := log.With("request_id", requestID, "client_ip", r.RemoteAddr)
 
-	logger.Info("Processing decrypt request", "method", r.Method, "path", r.URL.Path)
-
 	if r.Method != http.MethodPost {
-		logger.Warn("Invalid method used", "method", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.Warn("Invalid method used", "method", r.Method, "path", r.URL.Path)
+		writeError(w, newAPIError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed", nil))
 		return
 	}
 
-	err := r.ParseMultipartForm(32 << 20) // 32 MB max
+	op, err := newOp()
 	if err != nil {
-		logger.Error("Failed to parse multipart form", "error", err)
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 
-	file, header, err := r.FormFile("zipfile")
+	logger.Info("Processing "+op.Name+" request", "method", r.Method, "path", r.URL.Path)
+
+	mode, err := parseResponseMode(r.URL.Query().Get("mode"), op)
 	if err != nil {
-		logger.Error("Failed to get uploaded file", "error", err)
-		http.Error(w, "Failed to get uploaded file", http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
-	defer file.Close()
-
-	logger.Info("File uploaded", "filename", header.Filename, "size", header.Size)
 
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
-		logger.Warn("Invalid file extension", "filename", header.Filename)
-		http.Error(w, "File must be a ZIP archive", http.StatusBadRequest)
+	if apiErr := checkDiskSpace(cfg, r.ContentLength); apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
 
-	tempDir, err := os.MkdirTemp("", "necrack-*")
+	tempDir, err := os.MkdirTemp(cfg.TempDir, "necrack-*")
 	if err != nil {
 		logger.Error("Failed to create temp directory", "error", err)
-		http.Error(w, "Failed to create temp directory", http.StatusInternalServerError)
+		writeError(w, internalError("Failed to create temp directory", err))
 		return
 	}
 	defer func() {
@@ -64,206 +89,50 @@ func DecryptHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug("Created temp directory", "temp_dir", tempDir)
 
-	tempZipPath := filepath.Join(tempDir, "input.zip")
-	tempZipFile, err := os.Create(tempZipPath)
+	filename, tempZipPath, err := saveUpload(w, r, tempDir, cfg.maxUploadSize(), logger)
 	if err != nil {
-		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	_, err = io.Copy(tempZipFile, file)
-	tempZipFile.Close()
+	result, err := processArchive(r.Context(), cfg, op, tempDir, tempZipPath, mode, logger, nil)
 	if err != nil {
-		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
-		return
-	}
-
-	extractDir := filepath.Join(tempDir, "extracted")
-	if err := extractZip(tempZipPath, extractDir); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to extract ZIP: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	worldDirs, err := findWorldDirectories(extractDir)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to find world directories: %v", err), http.StatusInternalServerError)
-		return
+	if op.Verify {
+		w.Header().Set("X-Necrack-Verified", strconv.Itoa(result.Verified))
+		w.Header().Set("X-Necrack-Verify-Warnings", strconv.Itoa(result.Warnings))
 	}
-
-	if len(worldDirs) == 0 {
-		logger.Warn("No world directories found in ZIP")
-		http.Error(w, "No world directories found in ZIP", http.StatusBadRequest)
-		return
-	}
-
-	logger.Info("Found world directories", "count", len(worldDirs), "directories", worldDirs)
-
-	decryptedDirs := make([]string, 0, len(worldDirs))
-	for _, worldDir := range worldDirs {
-		logger.Info("Decrypting world", "world_dir", worldDir)
-		decryptedDir, err := netease.DecryptWorldDB(worldDir)
-		if err != nil {
-			logger.Error("Failed to decrypt world", "world_dir", worldDir, "error", err)
-			http.Error(w, fmt.Sprintf("Failed to decrypt world: %v", err), http.StatusInternalServerError)
-			return
-		}
-		decryptedDirs = append(decryptedDirs, decryptedDir)
-		logger.Info("World decrypted successfully", "world_dir", worldDir, "decrypted_dir", decryptedDir)
-	}
-
-	outputZipPath := filepath.Join(tempDir, "decrypted.zip")
-	if err := createZip(extractDir, outputZipPath); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create output ZIP: %v", err), http.StatusInternalServerError)
-		return
-	}
-
+	w.Header().Set("X-Necrack-Failed-Worlds", strconv.Itoa(result.Report.Failed))
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=decrypted_"+header.Filename)
+	w.Header().Set("Content-Disposition", "attachment; filename="+op.Label+"_"+filename)
 
-	outputFile, err := os.Open(outputZipPath)
+	outputFile, err := os.Open(result.ZipPath)
 	if err != nil {
-		http.Error(w, "Failed to open output file", http.StatusInternalServerError)
+		writeError(w, internalError("Failed to open output file", err))
 		return
 	}
 	defer outputFile.Close()
 
 	bytesWritten, err := io.Copy(w, outputFile)
 	if err != nil {
+		// The body is partly sent, so the client cannot be told.
 		logger.Error("Failed to send response", "error", err)
-		http.Error(w, "Failed to send response", http.StatusInternalServerError)
 		return
 	}
 
 	logger.Info("Request completed successfully",
-		"filename", header.Filename,
-		"worlds_processed", len(worldDirs),
+		"operation", op.Name,
+		"filename", filename,
+		"worlds_processed", len(result.Report.Worlds),
+		"worlds_failed", result.Report.Failed,
 		"response_size", bytesWritten,
 		"duration", time.Since(start),
 	)
 }
 
-func extractZip(src, dest string) error {
-	r, err := zip.OpenReader(src)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	if err := os.MkdirAll(dest, 0755); err != nil {
-		return err
-	}
-
-	for _, f := range r.File {
-		rc, err := f.Open()
-		if err != nil {
-			return err
-		}
-
-		path := filepath.Join(dest, f.Name)
-		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
-			rc.Close()
-			return fmt.Errorf("invalid file path: %s", f.Name)
-		}
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(path, f.FileInfo().Mode())
-			rc.Close()
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			rc.Close()
-			return err
-		}
-
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.FileInfo().Mode())
-		if err != nil {
-			rc.Close()
-			return err
-		}
-
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func createZip(src, dest string) error {
-	zipFile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer zipFile.Close()
-
-	archive := zip.NewWriter(zipFile)
-	defer archive.Close()
-
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.ToSlash(relPath)
-
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
-
-		writer, err := archive.CreateHeader(header)
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		_, err = io.Copy(writer, file)
-		return err
-	})
-}
-
-func findWorldDirectories(root string) ([]string, error) {
-	var worldDirs []string
-
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() && info.Name() == "db" {
-			worldDir := filepath.Dir(path)
-			worldDirs = append(worldDirs, worldDir)
-		}
-
-		return nil
-	})
-
-	return worldDirs, err
-}
-
 func generateRequestID() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
 }
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/yechentide/necrack/netease"
+)
+
+// JobStatus is the state of an asynchronous decryption job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// JobProgress reports how far a running job is. Files counts the files of
+// the world that is currently being decrypted.
+type JobProgress struct {
+	World      int `json:"world"`
+	Worlds     int `json:"worlds"`
+	FilesDone  int `json:"files_done"`
+	FilesTotal int `json:"files_total"`
+}
+
+// Job is an uploaded archive that is decrypted in the background.
+type Job struct {
+	ID             string        `json:"id"`
+	Status         JobStatus     `json:"status"`
+	Filename       string        `json:"filename"`
+	Mode           ResponseMode  `json:"mode"`
+	Error          *APIError     `json:"error,omitempty"`
+	Progress       JobProgress   `json:"progress"`
+	VerifiedFiles  int           `json:"verified_files"`
+	VerifyWarnings int           `json:"verify_warnings"`
+	FailedWorlds   int           `json:"failed_worlds"`
+	Worlds         []WorldReport `json:"worlds,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	StartedAt      time.Time     `json:"started_at,omitzero"`
+	FinishedAt     time.Time     `json:"finished_at,omitzero"`
+	ExpiresAt      time.Time     `json:"expires_at,omitzero"`
+
+	dir        string
+	zipPath    string
+	resultPath string
+	// owner is the principal that created the job, empty without
+	// authentication. Other principals cannot see the job.
+	owner string
+}
+
+// JobManager runs decryption jobs on a bounded pool of workers and removes
+// finished jobs once their TTL has passed.
+type JobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+	ttl   time.Duration
+	cfg   Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// draining is closed by Shutdown. Workers then finish the queue and
+	// exit, and no new jobs are accepted.
+	draining  chan struct{}
+	drainOnce sync.Once
+	workers   sync.WaitGroup
+}
+
+// NewJobManager starts workers goroutines that process up to queueSize
+// waiting jobs within the limits of cfg. Results of finished jobs are kept
+// for ttl.
+func NewJobManager(cfg Config, workers, queueSize int, ttl time.Duration) *JobManager {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &JobManager{
+		jobs:     make(map[string]*Job),
+		queue:    make(chan *Job, queueSize),
+		ttl:      ttl,
+		cfg:      cfg,
+		ctx:      ctx,
+		cancel:   cancel,
+		draining: make(chan struct{}),
+	}
+
+	for range workers {
+		m.workers.Add(1)
+		go m.work()
+	}
+
+	m.wg.Add(1)
+	go m.cleanup()
+
+	return m
+}
+
+// Shutdown stops accepting jobs and waits for the queued and running ones
+// to finish, until ctx is done. Jobs left at that point are canceled. Once
+// the workers have stopped, the files of every job are removed as by Close.
+// It returns ctx.Err() if jobs had to be canceled.
+func (m *JobManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.drainOnce.Do(func() { close(m.draining) })
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	m.Close()
+	return err
+}
+
+// Close cancels running jobs, stops the workers and removes the files of
+// every job.
+func (m *JobManager) Close() {
+	m.cancel()
+	m.workers.Wait()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, job := range m.jobs {
+		os.RemoveAll(job.dir)
+		delete(m.jobs, id)
+	}
+}
+
+// CreateHandler accepts an upload like DecryptHandler and queues it as a job.
+// It responds with 202 and the job, or 503 when the queue is full.
+func (m *JobManager) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	logger := log.With("request_id", generateRequestID(), "client_ip", r.RemoteAddr)
+	logger.Info("Processing job request", "method", r.Method, "path", r.URL.Path)
+
+	mode, err := parseResponseMode(r.URL.Query().Get("mode"), decryptOperation)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		logger.Error("Failed to generate job ID", "error", err)
+		writeError(w, internalError("Failed to create job", err))
+		return
+	}
+
+	if apiErr := checkDiskSpace(m.cfg, r.ContentLength); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	tempDir, err := os.MkdirTemp(m.cfg.TempDir, "necrack-job-*")
+	if err != nil {
+		logger.Error("Failed to create temp directory", "error", err)
+		writeError(w, internalError("Failed to create temp directory", err))
+		return
+	}
+
+	filename, zipPath, err := saveUpload(w, r, tempDir, m.cfg.maxUploadSize(), logger)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		writeError(w, err)
+		return
+	}
+
+	var owner string
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		owner = principal.Name
+	}
+
+	job := &Job{
+		ID:        id,
+		Status:    JobQueued,
+		Filename:  filename,
+		Mode:      mode,
+		CreatedAt: time.Now(),
+		dir:       tempDir,
+		zipPath:   zipPath,
+		owner:     owner,
+	}
+
+	var apiErr *APIError
+	m.mu.Lock()
+	select {
+	case <-m.draining:
+		apiErr = newAPIError(http.StatusServiceUnavailable, CodeServerBusy, "Server is shutting down, try again later", nil)
+	default:
+		select {
+		case m.queue <- job:
+			m.jobs[id] = job
+		default:
+			apiErr = newAPIError(http.StatusServiceUnavailable, CodeQueueFull, "Job queue is full, try again later", nil)
+		}
+	}
+	m.mu.Unlock()
+
+	if apiErr != nil {
+		os.RemoveAll(tempDir)
+		logger.Warn("Job rejected", "code", apiErr.Code)
+		apiErr.RetryAfter = busyRetryAfter
+		writeError(w, apiErr)
+		return
+	}
+
+	logger.Info("Job queued", "job_id", id, "filename", filename)
+
+	w.Header().Set("Location", "/jobs/"+id)
+	m.writeJob(w, r, id, http.StatusAccepted)
+}
+
+// StatusHandler reports the job named by the id path value.
+func (m *JobManager) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	m.writeJob(w, r, r.PathValue("id"), http.StatusOK)
+}
+
+// ResultHandler sends the decrypted archive of a succeeded job. It responds
+// with 409 while the job has not succeeded.
+func (m *JobManager) ResultHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	job, ok := m.lookup(r, r.PathValue("id"))
+	if !ok {
+		m.mu.Unlock()
+		writeError(w, newAPIError(http.StatusNotFound, CodeJobNotFound, "Job not found", nil))
+		return
+	}
+	status, filename, resultPath := job.Status, job.Filename, job.resultPath
+	var result *os.File
+	var err error
+	if status == JobSucceeded {
+		// Opened under the lock so cleanup cannot remove it first.
+		result, err = os.Open(resultPath)
+	}
+	m.mu.Unlock()
+
+	if status != JobSucceeded {
+		writeError(w, newAPIError(http.StatusConflict, CodeJobNotReady, fmt.Sprintf("Job is %s", status), nil))
+		return
+	}
+	if err != nil {
+		writeError(w, internalError("Failed to open output file", err))
+		return
+	}
+	defer result.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=decrypted_"+filename)
+	if _, err := io.Copy(w, result); err != nil {
+		log.Error("Failed to send job result", "job_id", r.PathValue("id"), "error", err)
+	}
+}
+
+func (m *JobManager) writeJob(w http.ResponseWriter, r *http.Request, id string, status int) {
+	m.mu.Lock()
+	job, ok := m.lookup(r, id)
+	var snapshot Job
+	if ok {
+		snapshot = *job
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, CodeJobNotFound, "Job not found", nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// lookup returns the job with id if the client of r may see it. The caller
+// must hold m.mu.
+func (m *JobManager) lookup(r *http.Request, id string) (*Job, bool) {
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	if principal, ok := PrincipalFromContext(r.Context()); ok && principal.Name != job.owner {
+		return nil, false
+	}
+	return job, true
+}
+
+func (m *JobManager) work() {
+	defer m.workers.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(job)
+		case <-m.draining:
+			// Queued jobs are still run, as they were accepted.
+			select {
+			case job := <-m.queue:
+				m.run(job)
+			default:
+				return
+			}
+		}
+	}
+}
+
+func (m *JobManager) run(job *Job) {
+	logger := log.With("job_id", job.ID)
+
+	m.update(func() {
+		job.Status = JobRunning
+		job.StartedAt = time.Now()
+	})
+	logger.Info("Job started", "filename", job.Filename)
+
+	result, err := processArchive(m.ctx, m.cfg, decryptOperation, job.dir, job.zipPath, job.Mode, logger, func(world, worlds int, event netease.ProgressEvent) {
+		if event.Kind == netease.ProgressFileVerified {
+			return
+		}
+		m.update(func() {
+			job.Progress = JobProgress{
+				World:      world + 1,
+				Worlds:     worlds,
+				FilesDone:  event.Done,
+				FilesTotal: event.Total,
+			}
+		})
+	})
+
+	// Only the result is kept until the job expires. Everything else in the
+	// job directory is a copy of the upload, decrypted or not.
+	resultPath := ""
+	if err == nil {
+		resultPath = result.ZipPath
+	}
+	cleanJobDir(job.dir, resultPath, logger)
+
+	m.update(func() {
+		job.FinishedAt = time.Now()
+		job.ExpiresAt = job.FinishedAt.Add(m.ttl)
+		if err != nil {
+			job.Status = JobFailed
+			if !errors.As(err, &job.Error) {
+				job.Error = internalError("Job failed", err)
+			}
+			return
+		}
+		job.Status = JobSucceeded
+		job.resultPath = result.ZipPath
+		job.VerifiedFiles = result.Verified
+		job.VerifyWarnings = result.Warnings
+		job.FailedWorlds = result.Report.Failed
+		job.Worlds = result.Report.Worlds
+	})
+
+	if err != nil {
+		logger.Error("Job failed", "error", err)
+		return
+	}
+	logger.Info("Job succeeded", "worlds_processed", len(result.Report.Worlds), "worlds_failed", result.Report.Failed, "duration", time.Since(job.StartedAt))
+}
+
+func (m *JobManager) update(change func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change()
+}
+
+// cleanup removes expired jobs until the manager is closed.
+func (m *JobManager) cleanup() {
+	defer m.wg.Done()
+
+	interval := m.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.removeExpired(now)
+		}
+	}
+}
+
+func (m *JobManager) removeExpired(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, job := range m.jobs {
+		if job.ExpiresAt.IsZero() || now.Before(job.ExpiresAt) {
+			continue
+		}
+		if err := os.RemoveAll(job.dir); err != nil {
+			log.Warn("Failed to clean job directory", "job_id", id, "error", err)
+		}
+		delete(m.jobs, id)
+		log.Debug("Job expired", "job_id", id)
+	}
+}
+
+// newJobID returns a random ID. Job IDs grant access to results, so they must
+// not be guessable.
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// cleanJobDir removes every entry of the job directory dir except the file
+// keep.
+func cleanJobDir(dir, keep string, logger *log.Logger) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("Failed to clean job directory", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if path == keep {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			logger.Warn("Failed to clean job directory", "path", path, "error", err)
+		}
+	}
+}
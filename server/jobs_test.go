@@ -0,0 +1,337 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yechentide/necrack/netease"
+)
+
+var testKey = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+// encryptedWorldZip returns an upload holding an encrypted world at world/,
+// made of the smallest db that decrypts and verifies: a CURRENT file.
+func encryptedWorldZip(t *testing.T) []byte {
+	t.Helper()
+
+	var current bytes.Buffer
+	w, err := netease.NewEncryptWriter(&current, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("MANIFEST-000002\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"world/db/CURRENT":    current.Bytes(),
+		"world/levelname.txt": []byte("Test"),
+	} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestJobManager returns a job manager working below a temporary
+// directory, closed at the end of the test.
+func newTestJobManager(t *testing.T, workers, queueSize int, ttl time.Duration) *JobManager {
+	t.Helper()
+	m := NewJobManager(Config{TempDir: t.TempDir()}, workers, queueSize, ttl)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// postJob uploads body as a new job and returns the response.
+func postJob(m *JobManager, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/zip")
+	w := httptest.NewRecorder()
+	m.CreateHandler(w, r)
+	return w
+}
+
+// decodeJob decodes the job in the body of a response.
+func decodeJob(t *testing.T, w *httptest.ResponseRecorder) Job {
+	t.Helper()
+	var job Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("decoding job: %v", err)
+	}
+	return job
+}
+
+// decodeError returns the code of the error in the body of a response.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error: %v", err)
+	}
+	return body.Error.Code
+}
+
+func TestJobManagerShutdownDrains(t *testing.T) {
+	m := newTestJobManager(t, 1, 4, time.Hour)
+	upload := encryptedWorldZip(t)
+
+	var jobs []*Job
+	for range 3 {
+		w := postJob(m, upload)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+		}
+		m.mu.Lock()
+		jobs = append(jobs, m.jobs[decodeJob(t, w).ID])
+		m.mu.Unlock()
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	for i, job := range jobs {
+		if job.Status != JobSucceeded {
+			t.Errorf("job %d status = %s, want %s", i, job.Status, JobSucceeded)
+		}
+	}
+
+	w := postJob(m, upload)
+	if w.Code != http.StatusServiceUnavailable || decodeError(t, w) != CodeServerBusy {
+		t.Errorf("job after shutdown: status = %d, want %d %s", w.Code, http.StatusServiceUnavailable, CodeServerBusy)
+	}
+}
+
+func TestJobManagerShutdownDeadline(t *testing.T) {
+	m := newTestJobManager(t, 1, 4, time.Hour)
+	for range 3 {
+		postJob(m, encryptedWorldZip(t))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Shutdown error = %v, want %v", err, context.Canceled)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.jobs) != 0 {
+		t.Errorf("%d jobs left after shutdown", len(m.jobs))
+	}
+}
+
+// newIdleJobManager returns a job manager without workers, so jobs stay
+// queued until the test runs them.
+func newIdleJobManager(t *testing.T, ttl time.Duration) *JobManager {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &JobManager{
+		jobs:     make(map[string]*Job),
+		queue:    make(chan *Job, 4),
+		ttl:      ttl,
+		cfg:      Config{TempDir: t.TempDir()},
+		ctx:      ctx,
+		cancel:   cancel,
+		draining: make(chan struct{}),
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+// serveJob calls handler for the job id and returns the response.
+func serveJob(handler http.HandlerFunc, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// queueJob uploads body to m and returns the queued job.
+func queueJob(t *testing.T, m *JobManager, body []byte) *Job {
+	t.Helper()
+	w := postJob(m, body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	id := decodeJob(t, w).ID
+	if got := w.Header().Get("Location"); got != "/jobs/"+id {
+		t.Errorf("Location = %q, want %q", got, "/jobs/"+id)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id]
+}
+
+func TestJobSucceeds(t *testing.T) {
+	m := newIdleJobManager(t, time.Hour)
+	job := queueJob(t, m, encryptedWorldZip(t))
+
+	w := serveJob(m.StatusHandler, job.ID)
+	if status := decodeJob(t, w).Status; w.Code != http.StatusOK || status != JobQueued {
+		t.Fatalf("queued job: %d %s, want %d %s", w.Code, status, http.StatusOK, JobQueued)
+	}
+	w = serveJob(m.ResultHandler, job.ID)
+	if w.Code != http.StatusConflict || decodeError(t, w) != CodeJobNotReady {
+		t.Errorf("result of queued job: status = %d, want %d %s", w.Code, http.StatusConflict, CodeJobNotReady)
+	}
+
+	m.run(<-m.queue)
+
+	snapshot := decodeJob(t, serveJob(m.StatusHandler, job.ID))
+	if snapshot.Status != JobSucceeded {
+		t.Fatalf("status = %s, want %s: %+v", snapshot.Status, JobSucceeded, snapshot.Error)
+	}
+	// The job went through running on the way.
+	if snapshot.StartedAt.IsZero() || snapshot.FinishedAt.Before(snapshot.StartedAt) {
+		t.Errorf("started at %v, finished at %v", snapshot.StartedAt, snapshot.FinishedAt)
+	}
+	if want := snapshot.FinishedAt.Add(time.Hour); !snapshot.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", snapshot.ExpiresAt, want)
+	}
+	if snapshot.Progress.FilesDone != 1 || snapshot.Progress.FilesTotal != 1 {
+		t.Errorf("progress = %+v, want 1 of 1 files", snapshot.Progress)
+	}
+
+	entries, err := os.ReadDir(job.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || filepath.Join(job.dir, entries[0].Name()) != job.resultPath {
+		t.Errorf("job directory holds %d entries, want only the result", len(entries))
+	}
+
+	w = serveJob(m.ResultHandler, job.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("result: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", got)
+	}
+	result, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("result is not a zip: %v", err)
+	}
+	var current []byte
+	for _, f := range result.File {
+		if path.Base(f.Name) == "CURRENT" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			current, err = io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if string(current) != "MANIFEST-000002\n" {
+		t.Errorf("decrypted CURRENT = %q, want %q", current, "MANIFEST-000002\n")
+	}
+}
+
+func TestJobFails(t *testing.T) {
+	m := newIdleJobManager(t, time.Hour)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	job := queueJob(t, m, buf.Bytes())
+	m.run(<-m.queue)
+
+	snapshot := decodeJob(t, serveJob(m.StatusHandler, job.ID))
+	if snapshot.Status != JobFailed {
+		t.Fatalf("status = %s, want %s", snapshot.Status, JobFailed)
+	}
+	if snapshot.Error == nil || snapshot.Error.Code == "" {
+		t.Errorf("failed job has no error code: %+v", snapshot.Error)
+	}
+	if snapshot.ExpiresAt.IsZero() {
+		t.Error("failed job never expires")
+	}
+
+	entries, err := os.ReadDir(job.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("job directory holds %d entries, want none", len(entries))
+	}
+
+	w := serveJob(m.ResultHandler, job.ID)
+	if w.Code != http.StatusConflict || decodeError(t, w) != CodeJobNotReady {
+		t.Errorf("result of failed job: status = %d, want %d %s", w.Code, http.StatusConflict, CodeJobNotReady)
+	}
+}
+
+func TestJobExpires(t *testing.T) {
+	m := newIdleJobManager(t, time.Minute)
+	job := queueJob(t, m, encryptedWorldZip(t))
+	queued := queueJob(t, m, encryptedWorldZip(t))
+	m.run(<-m.queue)
+
+	m.removeExpired(job.ExpiresAt.Add(-time.Nanosecond))
+	if w := serveJob(m.StatusHandler, job.ID); w.Code != http.StatusOK {
+		t.Fatalf("job removed before its TTL: status = %d", w.Code)
+	}
+
+	m.removeExpired(job.ExpiresAt)
+	for _, handler := range []http.HandlerFunc{m.StatusHandler, m.ResultHandler} {
+		w := serveJob(handler, job.ID)
+		if w.Code != http.StatusNotFound || decodeError(t, w) != CodeJobNotFound {
+			t.Errorf("expired job: status = %d, want %d %s", w.Code, http.StatusNotFound, CodeJobNotFound)
+		}
+	}
+	if _, err := os.Stat(job.dir); !os.IsNotExist(err) {
+		t.Errorf("directory of expired job not removed: %v", err)
+	}
+
+	// Unfinished jobs do not expire.
+	m.removeExpired(time.Now().Add(24 * time.Hour))
+	if w := serveJob(m.StatusHandler, queued.ID); w.Code != http.StatusOK {
+		t.Errorf("queued job removed: status = %d", w.Code)
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	m := newIdleJobManager(t, time.Hour)
+	job := queueJob(t, m, encryptedWorldZip(t))
+
+	for _, id := range []string{"0123456789abcdef0123456789abcdef", "", job.ID + "0"} {
+		for _, handler := range []http.HandlerFunc{m.StatusHandler, m.ResultHandler} {
+			w := serveJob(handler, id)
+			if w.Code != http.StatusNotFound || decodeError(t, w) != CodeJobNotFound {
+				t.Errorf("job %q: status = %d, want %d %s", id, w.Code, http.StatusNotFound, CodeJobNotFound)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// busyRetryAfter is the Retry-After sent when all processing slots are taken.
+const busyRetryAfter = 10 * time.Second
+
+// ConcurrencyLimiter is middleware that lets at most a fixed number of
+// requests run at once and rejects the others with 503.
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+// NewConcurrencyLimiter returns a limiter for n concurrent requests.
+func NewConcurrencyLimiter(n int) *ConcurrencyLimiter {
+	if n < 1 {
+		n = 1
+	}
+	return &ConcurrencyLimiter{slots: make(chan struct{}, n)}
+}
+
+// Wrap limits the requests served by next. Requests are rejected before
+// their body is read, so a busy server does not buffer uploads.
+func (l *ConcurrencyLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			log.Warn("Server busy", "client_ip", r.RemoteAddr, "path", r.URL.Path, "limit", cap(l.slots))
+			apiErr := newAPIError(http.StatusServiceUnavailable, CodeServerBusy, "Server is busy, try again later", nil)
+			apiErr.RetryAfter = busyRetryAfter
+			writeError(w, apiErr)
+			return
+		}
+		defer func() { <-l.slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimiter is middleware that limits the request rate of every client IP
+// with a token bucket.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows every client IP rate requests per second on
+// average, and bursts of up to burst requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Wrap rejects requests over the rate of their client IP with 429.
+func (l *RateLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if wait, ok := l.allow(ip, time.Now()); !ok {
+			log.Warn("Rate limit exceeded", "client_ip", ip, "path", r.URL.Path)
+			apiErr := newAPIError(http.StatusTooManyRequests, CodeRateLimited, "Too many requests, slow down", nil)
+			apiErr.RetryAfter = wait
+			writeError(w, apiErr)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the bucket of ip. Without a token it returns
+// false and the time until the next token.
+func (l *RateLimiter) allow(ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep forgets buckets that have refilled, at most once a minute, so the
+// map does not grow with every client ever seen.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// clientIP returns the IP of the peer. Forwarding headers are ignored, as
+// they can be set by any client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// diskRetryAfter is the Retry-After sent when the disk is too full.
+const diskRetryAfter = time.Minute
+
+// checkDiskSpace makes sure that cfg.TempDir keeps at least cfg.MinFreeDisk
+// bytes free after an upload of size bytes, which is -1 when unknown.
+func checkDiskSpace(cfg Config, size int64) *APIError {
+	if cfg.MinFreeDisk <= 0 {
+		return nil
+	}
+	dir := cfg.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			log.Warn("Failed to check free disk space", "dir", dir, "error", err)
+		}
+		return nil
+	}
+
+	need := uint64(cfg.MinFreeDisk + max(size, 0))
+	if free >= need {
+		return nil
+	}
+
+	log.Warn("Not enough free disk space", "dir", dir, "free", free, "need", need)
+	apiErr := newAPIError(http.StatusServiceUnavailable, CodeInsufficientStorage, "Not enough free disk space, try again later", nil)
+	apiErr.RetryAfter = diskRetryAfter
+	return apiErr
+}
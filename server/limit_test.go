@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := NewConcurrencyLimiter(1).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve("/slow") }()
+	<-started
+
+	w := serve("/fast")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("saturated: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if code := decodeError(t, w); code != CodeServerBusy {
+		t.Errorf("saturated: code = %s, want %s", code, CodeServerBusy)
+	}
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("saturated: Retry-After = %q, want %q", got, "10")
+	}
+
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("slow request: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The slot is released once the handler returns.
+	for i := range 3 {
+		if w := serve("/fast"); w.Code != http.StatusOK {
+			t.Errorf("request %d after release: status = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestConcurrencyLimiterReleasesOnPanic(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1)
+	panicking := limiter.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	func() {
+		defer func() { recover() }()
+		panicking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	w := httptest.NewRecorder()
+	limiter.Wrap(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status after panic = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	handler := NewRateLimiter(1, 2).Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := range 2 {
+		if w := serve("192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("burst request %d: status = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+
+	// Another port of the same client shares its bucket.
+	w := serve("192.0.2.1:2000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("over rate: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if code := decodeError(t, w); code != CodeRateLimited {
+		t.Errorf("over rate: code = %s, want %s", code, CodeRateLimited)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("over rate: Retry-After = %q, want %q", got, "1")
+	}
+
+	if w := serve("192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := NewRateLimiter(2, 3)
+	now := time.Now()
+
+	for i := range 3 {
+		if _, ok := l.allow("ip", now); !ok {
+			t.Fatalf("burst request %d rejected", i+1)
+		}
+	}
+	wait, ok := l.allow("ip", now)
+	if ok {
+		t.Fatal("request over the burst allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want %v", wait, 500*time.Millisecond)
+	}
+
+	// Half a second refills one token at two per second.
+	now = now.Add(500 * time.Millisecond)
+	if _, ok := l.allow("ip", now); !ok {
+		t.Error("request after one token refilled rejected")
+	}
+	if _, ok := l.allow("ip", now); ok {
+		t.Error("second request after one token refilled allowed")
+	}
+
+	// A long pause refills the bucket up to the burst, not beyond.
+	now = now.Add(time.Hour)
+	for i := range 3 {
+		if _, ok := l.allow("ip", now); !ok {
+			t.Fatalf("request %d after refill rejected", i+1)
+		}
+	}
+	if _, ok := l.allow("ip", now); ok {
+		t.Error("request over the refilled burst allowed")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	now := time.Now()
+	l.allow("old", now)
+	l.allow("new", now.Add(2*time.Minute))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets["old"]; ok {
+		t.Error("refilled bucket kept after sweep")
+	}
+	if _, ok := l.buckets["new"]; !ok {
+		t.Error("active bucket removed by sweep")
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := freeDiskSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free disk space is not available on this platform")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	const margin = 1 << 30 // other processes may use the disk meanwhile
+
+	for _, tt := range []struct {
+		name        string
+		minFreeDisk int64
+		size        int64
+		wantErr     bool
+	}{
+		{name: "disabled", minFreeDisk: 0, size: int64(free) + margin},
+		{name: "enough space", minFreeDisk: 1, size: -1},
+		{name: "reserve too large", minFreeDisk: int64(free) + margin, size: -1, wantErr: true},
+		{name: "upload too large", minFreeDisk: 1, size: int64(free) + margin, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := checkDiskSpace(Config{TempDir: dir, MinFreeDisk: tt.minFreeDisk}, tt.size)
+			if !tt.wantErr {
+				if apiErr != nil {
+					t.Errorf("checkDiskSpace = %v, want nil", apiErr)
+				}
+				return
+			}
+			if apiErr == nil {
+				t.Fatal("checkDiskSpace = nil, want an error")
+			}
+			if apiErr.Status != http.StatusServiceUnavailable || apiErr.Code != CodeInsufficientStorage {
+				t.Errorf("checkDiskSpace = %d %s, want %d %s", apiErr.Status, apiErr.Code, http.StatusServiceUnavailable, CodeInsufficientStorage)
+			}
+			if apiErr.RetryAfter != diskRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", apiErr.RetryAfter, diskRetryAfter)
+			}
+		})
+	}
+}
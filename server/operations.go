@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/yechentide/necrack/netease"
+)
+
+// operation is a transformation the server applies to every world of an
+// upload.
+type operation struct {
+	// Name is used in logs, e.g. "decrypt".
+	Name string
+	// Label is the status of processed worlds and prefixes the returned
+	// archive, e.g. "decrypted".
+	Label string
+	// Verify reports whether Process checks its output, which is reported in
+	// the X-Necrack-Verified and X-Necrack-Verify-Warnings headers.
+	Verify bool
+	// Process transforms a single world.
+	Process func(worldTask) *APIError
+}
+
+// worldTask is a single world of an upload handed to operation.Process.
+type worldTask struct {
+	Context context.Context
+	// UploadDir is the root of the extracted upload.
+	UploadDir string
+	WorldDir  string
+	// OutputDir receives the processed world. When empty, a timestamped
+	// copy is created next to WorldDir.
+	OutputDir string
+	Report    *WorldReport
+	Logger    *log.Logger
+	Progress  func(netease.ProgressEvent)
+}
+
+var decryptOperation = &operation{Name: "decrypt", Label: WorldDecrypted, Verify: true, Process: decryptWorld}
+
+// decryptWorld decrypts a world and fills in its report. The key is derived
+// up front, so failures carry a code that tells clients why the world could
+// not be decrypted.
+func decryptWorld(t worldTask) *APIError {
+	ctx, worldDir, report, logger := t.Context, t.WorldDir, t.Report, t.Logger
+	dbDir := filepath.Join(worldDir, "db")
+
+	headers, err := netease.CountHeaderTypes(dbDir)
+	if err != nil {
+		return internalError("Failed to read world files", err)
+	}
+	report.Headers = headers
+
+	encrypted := 0
+	for headerType, count := range headers {
+		if headerType.Encrypted() {
+			encrypted += count
+		}
+	}
+	if encrypted == 0 {
+		return newAPIError(http.StatusUnprocessableEntity, CodeNotEncrypted, "World is not encrypted", nil)
+	}
+
+	derived, err := netease.DeriveKeyWithStrategy(dbDir)
+	if errors.Is(err, netease.ErrLegacyEncryption) {
+		return newAPIError(http.StatusUnprocessableEntity, CodeUnsupportedLegacy,
+			"Legacy NetEase worlds are not supported", err)
+	}
+	if errors.Is(err, netease.ErrKeyNotDerivable) {
+		return newAPIError(http.StatusUnprocessableEntity, CodeUnsupportedLegacy,
+			"The key of this world cannot be derived, so it cannot be decrypted by the server", err)
+	}
+	if err != nil {
+		return newAPIError(http.StatusUnprocessableEntity, CodeKeyDerivationFailed, "Failed to derive the key of the world", err)
+	}
+	report.Key = hex.EncodeToString(derived.Key)
+
+	var verifyMu sync.Mutex
+	verifyCounts := make(map[netease.CheckStatus]int)
+
+	logger.Info("Decrypting world", "world_dir", worldDir, "key_strategy", derived.Strategy)
+	decryptedDir, err := netease.DecryptWorldDBWithOptions(worldDir, netease.WorldOptions{
+		Key:       derived.Key,
+		OutputDir: t.OutputDir,
+		Verify:    true,
+		Context:   ctx,
+		Logger:    logger,
+		Progress: func(event netease.ProgressEvent) {
+			switch event.Kind {
+			case netease.ProgressFileProcessed:
+				logger.Debug("File decrypted", "path", event.Path, "done", event.Done, "total", event.Total)
+			case netease.ProgressFileVerified:
+				verifyMu.Lock()
+				verifyCounts[event.Check.Status]++
+				verifyMu.Unlock()
+			}
+			t.Progress(event)
+		},
+	})
+	report.VerifiedFiles = verifyCounts[netease.CheckPassed]
+	report.VerifyWarnings = verifyCounts[netease.CheckWarning]
+
+	switch {
+	case err == nil:
+		logger.Info("World decrypted successfully", "world_dir", worldDir, "decrypted_dir", decryptedDir)
+		return nil
+	case ctx.Err() != nil:
+		return newAPIError(http.StatusServiceUnavailable, CodeCanceled, "Decryption was canceled", ctx.Err())
+	case errors.Is(err, netease.ErrKeyMismatch):
+		return newAPIError(http.StatusUnprocessableEntity, CodeVerificationFailed, "Decrypted world failed verification, the derived key is probably wrong", err)
+	case errors.Is(err, netease.ErrCorrupted):
+		return newAPIError(http.StatusUnprocessableEntity, CodeCorruptedWorld, "Decrypted world failed verification, some of its files are damaged", err)
+	default:
+		return newAPIError(http.StatusInternalServerError, CodeDecryptionFailed, "Failed to decrypt world", err)
+	}
+}
+
+// keyOriginal is the key query parameter value that reuses the keys listed in
+// the report.json of the upload.
+const keyOriginal = "original"
+
+// newEncryptOperation returns the encryption operation for the codec and key
+// query parameters. The codec defaults to netease.DefaultCodec. The key is a
+// hex key used for every world, "original" to reuse the key of each world
+// from the report.json of a previous decryption, or empty for a random key
+// per world.
+func newEncryptOperation(codecParam, keyParam string) (*operation, error) {
+	codec := netease.DefaultCodec
+	if codecParam != "" {
+		var ok bool
+		codec, ok = netease.LookupCodec(codecParam)
+		if !ok {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Unknown codec %q", codecParam), nil)
+		}
+		// Codecs that only detect their files, like the legacy one, cannot
+		// encrypt.
+		if _, err := codec.NewEncrypter(make([]byte, codec.KeySize())); err != nil {
+			return nil, newAPIError(http.StatusBadRequest, CodeUnsupportedLegacy, fmt.Sprintf("Codec %q cannot encrypt", codecParam), err)
+		}
+	}
+
+	var fixedKey []byte
+	if keyParam != "" && keyParam != keyOriginal {
+		key, err := netease.ParseCodecHexKey(codec, keyParam)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidKey, "Invalid key: "+err.Error(), err)
+		}
+		fixedKey = key
+	}
+
+	return &operation{
+		Name:  "encrypt",
+		Label: WorldEncrypted,
+		Process: func(t worldTask) *APIError {
+			key := fixedKey
+			if keyParam == keyOriginal {
+				var apiErr *APIError
+				key, apiErr = originalKey(t.UploadDir, t.Report.Path, codec)
+				if apiErr != nil {
+					return apiErr
+				}
+			}
+			return encryptWorld(t, codec, key)
+		},
+	}, nil
+}
+
+// originalKey looks up the key of the world at path in the report.json at the
+// root of the upload.
+func originalKey(uploadDir, path string, codec netease.Codec) ([]byte, *APIError) {
+	data, err := os.ReadFile(filepath.Join(uploadDir, reportFile))
+	if err != nil {
+		return nil, newAPIError(http.StatusUnprocessableEntity, CodeMissingKey, "Upload has no report.json to take the original key from", err)
+	}
+
+	// Only the fields needed here are decoded, so reports of other versions
+	// still work.
+	var report struct {
+		Worlds []struct {
+			Path string `json:"path"`
+			Key  string `json:"key"`
+		} `json:"worlds"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "report.json of the upload is not valid", err)
+	}
+
+	for _, world := range report.Worlds {
+		if world.Path != path || world.Key == "" {
+			continue
+		}
+		key, err := netease.ParseCodecHexKey(codec, world.Key)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidKey, "Invalid key in report.json: "+err.Error(), err)
+		}
+		return key, nil
+	}
+
+	return nil, newAPIError(http.StatusUnprocessableEntity, CodeMissingKey, "report.json of the upload has no key for this world", nil)
+}
+
+// encryptWorld encrypts a vanilla world with codec and key, or a random key
+// when key is nil, and fills in its report.
+func encryptWorld(t worldTask, codec netease.Codec, key []byte) *APIError {
+	ctx, worldDir, report, logger := t.Context, t.WorldDir, t.Report, t.Logger
+
+	headers, err := netease.CountHeaderTypes(filepath.Join(worldDir, "db"))
+	if err != nil {
+		return internalError("Failed to read world files", err)
+	}
+	report.Headers = headers
+
+	for headerType, count := range headers {
+		if headerType.Encrypted() && count > 0 {
+			return newAPIError(http.StatusUnprocessableEntity, CodeNotVanilla, "World is already encrypted", nil)
+		}
+	}
+
+	if key == nil {
+		key, err = netease.GenerateCodecKey(codec)
+		if err != nil {
+			return internalError("Failed to generate key", err)
+		}
+	}
+	report.Key = hex.EncodeToString(key)
+
+	logger.Info("Encrypting world", "world_dir", worldDir, "codec", codec.Name())
+	encryptedDir, err := netease.EncryptWorldDBWithOptions(worldDir, netease.WorldOptions{
+		Key:       key,
+		Codec:     codec,
+		OutputDir: t.OutputDir,
+		Context:   ctx,
+		Logger:    logger,
+		Progress: func(event netease.ProgressEvent) {
+			if event.Kind == netease.ProgressFileProcessed {
+				logger.Debug("File encrypted", "path", event.Path, "done", event.Done, "total", event.Total)
+			}
+			t.Progress(event)
+		},
+	})
+
+	switch {
+	case err == nil:
+		logger.Info("World encrypted successfully", "world_dir", worldDir, "encrypted_dir", encryptedDir)
+		return nil
+	case ctx.Err() != nil:
+		return newAPIError(http.StatusServiceUnavailable, CodeCanceled, "Encryption was canceled", ctx.Err())
+	default:
+		return newAPIError(http.StatusUnprocessableEntity, CodeEncryptionFailed, "Failed to encrypt world", err)
+	}
+}
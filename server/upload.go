@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// saveUpload streams the zip uploaded with r to input.zip in dir and returns
+// the client's file name and the path of the stored copy. The zip is either
+// the "zipfile" field of a multipart form or the raw body of an
+// application/zip request, named by the "filename" query parameter.
+func saveUpload(w http.ResponseWriter, r *http.Request, dir string, maxSize int64, logger *log.Logger) (string, string, error) {
+	if r.ContentLength > maxSize {
+		logger.Warn("Upload too large", "content_length", r.ContentLength, "max_size", maxSize)
+		return "", "", uploadTooLarge(maxSize)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", "", newAPIError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Missing or invalid Content-Type", err)
+	}
+
+	var filename string
+	var body io.Reader
+	switch mediaType {
+	case "application/zip", "application/x-zip-compressed":
+		filename = r.URL.Query().Get("filename")
+		if filename == "" {
+			filename = "upload.zip"
+		}
+		body = r.Body
+
+	case "multipart/form-data":
+		part, err := findZipPart(r)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return "", "", uploadTooLarge(maxSize)
+			}
+			logger.Error("Failed to get uploaded file", "error", err)
+			return "", "", newAPIError(http.StatusBadRequest, CodeInvalidRequest, "Form has no zipfile field", err)
+		}
+		defer part.Close()
+		filename = part.FileName()
+		body = part
+
+	default:
+		return "", "", newAPIError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Upload must be multipart/form-data or application/zip", nil)
+	}
+
+	filename = filepath.Base(filename)
+	if !strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		logger.Warn("Invalid file extension", "filename", filename)
+		return "", "", newAPIError(http.StatusBadRequest, CodeInvalidArchive, "File must be a ZIP archive", nil)
+	}
+
+	zipPath := filepath.Join(dir, "input.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return "", "", internalError("Failed to create temp file", err)
+	}
+
+	size, err := io.Copy(zipFile, body)
+	zipFile.Close()
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			logger.Warn("Upload too large", "max_size", maxSize)
+			return "", "", uploadTooLarge(maxSize)
+		}
+		return "", "", newAPIError(http.StatusBadRequest, CodeInvalidRequest, "Failed to read uploaded file", err)
+	}
+
+	logger.Info("File uploaded", "filename", filename, "size", size)
+
+	return filename, zipPath, nil
+}
+
+// findZipPart returns the "zipfile" part of a multipart request without
+// buffering the parts before it.
+func findZipPart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no zipfile field in form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "zipfile" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func uploadTooLarge(maxSize int64) error {
+	return newAPIError(http.StatusRequestEntityTooLarge, CodeUploadTooLarge,
+		fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxSize), nil)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+// multipartBody returns a form with data in the given field, and its
+// Content-Type.
+func multipartBody(t *testing.T, field, filename string, data []byte) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("note", "fields before the file are skipped"); err != nil {
+		t.Fatal(err)
+	}
+	part, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func TestSaveUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("z"), 100)
+	form, formType := multipartBody(t, "zipfile", "world.zip", data)
+	bigForm, bigFormType := multipartBody(t, "zipfile", "world.zip", bytes.Repeat(data, 10))
+	otherForm, otherFormType := multipartBody(t, "file", "world.zip", data)
+	textForm, textFormType := multipartBody(t, "zipfile", "world.txt", data)
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		query       string
+		body        []byte
+		// chunked hides the length of the body, so only the reader limit
+		// applies.
+		chunked      bool
+		wantFilename string
+		wantStatus   int
+		wantCode     string
+	}{
+		{name: "raw zip", contentType: "application/zip", query: "?filename=world.zip", body: data, wantFilename: "world.zip"},
+		{name: "raw zip without name", contentType: "application/zip", body: data, wantFilename: "upload.zip"},
+		{name: "raw zip with path", contentType: "application/zip", query: "?filename=../../world.zip", body: data, wantFilename: "world.zip"},
+		{name: "form", contentType: formType, body: form, wantFilename: "world.zip"},
+		{name: "content length too large", contentType: "application/zip", body: bytes.Repeat(data, 10), wantStatus: http.StatusRequestEntityTooLarge, wantCode: CodeUploadTooLarge},
+		{name: "chunked body too large", contentType: "application/zip", body: bytes.Repeat(data, 10), chunked: true, wantStatus: http.StatusRequestEntityTooLarge, wantCode: CodeUploadTooLarge},
+		{name: "chunked form too large", contentType: bigFormType, body: bigForm, chunked: true, wantStatus: http.StatusRequestEntityTooLarge, wantCode: CodeUploadTooLarge},
+		{name: "no zipfile field", contentType: otherFormType, body: otherForm, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{name: "not a zip name", contentType: textFormType, body: textForm, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArchive},
+		{name: "no content type", body: data, wantStatus: http.StatusUnsupportedMediaType, wantCode: CodeUnsupportedMediaType},
+		{name: "other content type", contentType: "application/json", body: data, wantStatus: http.StatusUnsupportedMediaType, wantCode: CodeUnsupportedMediaType},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(tt.body)
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/decrypt"+tt.query, body)
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			dir := t.TempDir()
+
+			filename, zipPath, err := saveUpload(w, r, dir, 500, log.New(io.Discard))
+			if tt.wantStatus != 0 {
+				if err == nil {
+					t.Fatalf("saveUpload succeeded, want %d %s", tt.wantStatus, tt.wantCode)
+				}
+				writeError(w, err)
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				if code := decodeError(t, w); code != tt.wantCode {
+					t.Errorf("code = %s, want %s", code, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("saveUpload: %v", err)
+			}
+
+			if filename != tt.wantFilename {
+				t.Errorf("filename = %q, want %q", filename, tt.wantFilename)
+			}
+			if want := filepath.Join(dir, "input.zip"); zipPath != want {
+				t.Errorf("path = %s, want %s", zipPath, want)
+			}
+			saved, err := os.ReadFile(zipPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, data) {
+				t.Errorf("saved %d bytes, want the %d uploaded", len(saved), len(data))
+			}
+		})
+	}
+}
+
+func TestCreateHandlerUploadTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	m := NewJobManager(Config{TempDir: dir, MaxUploadSize: 100}, 1, 1, 0)
+	t.Cleanup(m.Close)
+
+	w := postJob(m, encryptedWorldZip(t))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if code := decodeError(t, w); code != CodeUploadTooLarge {
+		t.Errorf("code = %s, want %s", code, CodeUploadTooLarge)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d entries left in the temp directory after a rejected upload", len(entries))
+	}
+}